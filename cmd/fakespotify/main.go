@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/thattomperson/spotifgo/internal/fakespotify"
+)
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "9090"
+	}
+
+	base := "http://localhost:" + port
+	log.Printf("fake spotify listening on %s", base)
+	log.Printf("run the app with SPOTIFY_ACCOUNTS_URL=%s SPOTIFY_API_URL=%s/v1/", base, base)
+
+	if err := http.ListenAndServe(":"+port, fakespotify.New()); err != nil {
+		log.Fatal(err)
+	}
+}
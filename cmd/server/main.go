@@ -4,7 +4,12 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // history filters use browser time zones the container may lack
 
 	"github.com/thattomperson/spotifgo/internal/app"
 	"github.com/thattomperson/spotifgo/internal/config"
@@ -14,9 +19,13 @@ import (
 func main() {
 	application := app.NewApp(config.NewConfig())
 
-	routes.SetupRoutes(application)
+	if err := routes.SetupRoutes(application); err != nil {
+		log.Fatal(err)
+	}
 
-	if err := application.Start(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := application.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
 }
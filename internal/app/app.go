@@ -1,25 +1,106 @@
 package app
 
 import (
+	"context"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/thattomperson/spotifgo/internal/config"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// shutdownTimeout is how long Start lets requests in flight finish.
+const shutdownTimeout = 10 * time.Second
+
 type App struct {
 	Config *config.Config
 	Router *chi.Mux
+	// Admin serves operator endpoints on Config.AdminAddr, apart from the
+	// public Router.
+	Admin *chi.Mux
+
+	server      *http.Server
+	adminServer *http.Server
+
+	// ctx is cancelled on Shutdown to stop background work.
+	ctx        context.Context
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+	closers    []io.Closer
 }
 
 func NewApp(config *config.Config) *App {
-	return &App{
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &App{
 		Config: config,
 		Router: chi.NewRouter(),
+		Admin:  chi.NewRouter(),
+		ctx:    ctx,
+		cancel: cancel,
 	}
+	a.server = &http.Server{Addr: ":" + config.Port, Handler: a.Router}
+	a.adminServer = &http.Server{Addr: config.AdminAddr, Handler: a.Admin}
+	return a
+}
+
+// Go runs fn in the background until the app shuts down, when its ctx is
+// cancelled and Shutdown waits for it to return.
+func (a *App) Go(fn func(ctx context.Context)) {
+	a.background.Go(func() {
+		fn(a.ctx)
+	})
 }
 
-func (a *App) Start() error {
-	return http.ListenAndServe(":"+a.Config.Port, a.Router)
+// CloseOnShutdown closes c once background work has stopped, e.g. a
+// database that work writes to. Closers run in reverse order of
+// registration.
+func (a *App) CloseOnShutdown(c io.Closer) {
+	a.closers = append(a.closers, c)
+}
+
+// Start serves until ctx is done, then shuts down.
+func (a *App) Start(ctx context.Context) error {
+	errs := make(chan error, 2)
+	if a.Config.AdminAddr != "" {
+		go func() {
+			log.Printf("admin endpoints on %s", a.Config.AdminAddr)
+			errs <- a.adminServer.ListenAndServe()
+		}()
+	}
+	go func() {
+		errs <- a.server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return errors.Join(err, a.Shutdown(shutdownCtx))
+}
+
+// Shutdown stops the servers once requests in flight finish, then stops
+// background work and closes what was registered with CloseOnShutdown.
+func (a *App) Shutdown(ctx context.Context) error {
+	err := errors.Join(a.server.Shutdown(ctx), a.adminServer.Shutdown(ctx))
+
+	a.cancel()
+	a.background.Wait()
+
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		err = errors.Join(err, a.closers[i].Close())
+	}
+	a.closers = nil
+	return err
 }
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/thattomperson/spotifgo/internal/config"
+)
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func TestShutdownStopsBackgroundWorkBeforeClosing(t *testing.T) {
+	a := NewApp(&config.Config{Port: "0"})
+
+	var events []string
+	a.CloseOnShutdown(closerFunc(func() error {
+		events = append(events, "close database")
+		return nil
+	}))
+	a.CloseOnShutdown(closerFunc(func() error {
+		events = append(events, "close cache")
+		return nil
+	}))
+	started := make(chan struct{})
+	a.Go(func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		events = append(events, "stop collector")
+	})
+	<-started
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"stop collector", "close cache", "close database"}; !slices.Equal(events, expected) {
+		t.Errorf("shutdown: got %v, want %v", events, expected)
+	}
+
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Errorf("second shutdown: %v", err)
+	}
+	if len(events) != 3 {
+		t.Errorf("second shutdown closed again: %v", events)
+	}
+}
+
+func TestStartShutsDownWhenDone(t *testing.T) {
+	a := NewApp(&config.Config{Port: "0"})
+	closed := false
+	a.CloseOnShutdown(closerFunc(func() error {
+		closed = true
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !closed {
+		t.Error("start: want everything closed once ctx is done")
+	}
+}
@@ -1,17 +1,23 @@
 package auth
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/thattomperson/spotifgo/internal/ratelimit"
 	"github.com/thattomperson/spotifgo/internal/utils"
 
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/zmb3/spotify/v2"
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
 )
 
 var scopes = []string{
@@ -24,37 +30,79 @@ var scopes = []string{
 	spotifyauth.ScopePlaylistModifyPublic,
 	spotifyauth.ScopePlaylistModifyPrivate,
 	spotifyauth.ScopePlaylistReadPrivate,
+	spotifyauth.ScopePlaylistReadCollaborative,
+	spotifyauth.ScopeUserLibraryRead,
+	spotifyauth.ScopeUserLibraryModify,
 }
 
 type Auth struct {
-	auth      *spotifyauth.Authenticator
-	tokenAuth *jwtauth.JWTAuth
-}
-
-func NewAuthenticator(redirectURL string, clientID string, clientSecret string) *spotifyauth.Authenticator {
-	return spotifyauth.New(
-		spotifyauth.WithRedirectURL(redirectURL),
-		spotifyauth.WithScopes(scopes...),
-		spotifyauth.WithClientID(clientID),
-		spotifyauth.WithClientSecret(clientSecret),
-	)
+	auth           *Authenticator
+	tokenAuth      *jwtauth.JWTAuth
+	trustedProxies *utils.TrustedProxies
+	transport      *ratelimit.Transport
+	apiURL         string
 }
 
 func NewTokenAuth(secret string) *jwtauth.JWTAuth {
 	return jwtauth.New("HS256", []byte(secret), nil)
 }
 
-func NewAuth(auth *spotifyauth.Authenticator, tokenAuth *jwtauth.JWTAuth) *Auth {
-	return &Auth{
+type AuthOption func(*Auth)
+
+// WithTrustedProxies makes the OAuth redirect URL follow the
+// X-Forwarded-Proto and X-Forwarded-Host headers set by trusted proxies.
+func WithTrustedProxies(proxies *utils.TrustedProxies) AuthOption {
+	return func(a *Auth) {
+		a.trustedProxies = proxies
+	}
+}
+
+// WithTransport routes Spotify API calls made by GetSpotifyClient through a
+// rate limiting transport.
+func WithTransport(transport *ratelimit.Transport) AuthOption {
+	return func(a *Auth) {
+		a.transport = transport
+	}
+}
+
+// WithAPIURL points clients from GetSpotifyClient at another Web API base
+// URL, e.g. "http://localhost:9090/v1/".
+func WithAPIURL(apiURL string) AuthOption {
+	return func(a *Auth) {
+		if apiURL != "" && !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		a.apiURL = apiURL
+	}
+}
+
+func NewAuth(auth *Authenticator, tokenAuth *jwtauth.JWTAuth, opts ...AuthOption) *Auth {
+	a := &Auth{
 		auth:      auth,
 		tokenAuth: tokenAuth,
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
 }
 
 func (a *Auth) AuthURL(state string) string {
 	return a.auth.AuthURL(state)
 }
 
+// redirectURLOptions overrides the configured redirect URL when the request
+// was forwarded by a trusted proxy, so the callback lands on the public host.
+func (a *Auth) redirectURLOptions(r *http.Request) []oauth2.AuthCodeOption {
+	if !a.trustedProxies.Trusts(r) || r.Header.Get("X-Forwarded-Host") == "" {
+		return nil
+	}
+
+	scheme, host := a.trustedProxies.Origin(r)
+	redirectURL := scheme + "://" + host + utils.Path(r.Context(), "/auth/callback")
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("redirect_uri", redirectURL)}
+}
+
 func (a *Auth) VerifierMiddleware() func(http.Handler) http.Handler {
 	return jwtauth.Verifier(a.tokenAuth)
 }
@@ -65,6 +113,8 @@ type authMiddlewareOptions struct {
 
 type AuthMiddlewareOption func(*authMiddlewareOptions)
 
+// WithRedirectUrl sets where unauthenticated requests are sent, as an
+// app-absolute path the base path is added to.
 func WithRedirectUrl(redirectURL string) AuthMiddlewareOption {
 	return func(options *authMiddlewareOptions) {
 		options.redirectUrl = redirectURL
@@ -86,13 +136,13 @@ func (a *Auth) AuthMiddleware(opts ...AuthMiddlewareOption) func(http.Handler) h
 
 			if err != nil {
 				log.Printf("Getting token from context failed: %v\n", err)
-				http.Redirect(w, r, options.redirectUrl, http.StatusTemporaryRedirect)
+				http.Redirect(w, r, utils.Path(r.Context(), options.redirectUrl), http.StatusTemporaryRedirect)
 				return
 			}
 
 			if token == nil {
 				log.Println("No token found in request context")
-				http.Redirect(w, r, options.redirectUrl, http.StatusTemporaryRedirect)
+				http.Redirect(w, r, utils.Path(r.Context(), options.redirectUrl), http.StatusTemporaryRedirect)
 				return
 			}
 
@@ -102,7 +152,9 @@ func (a *Auth) AuthMiddleware(opts ...AuthMiddlewareOption) func(http.Handler) h
 	}
 }
 
-func (a *Auth) GetSpotifyClient(r *http.Request) *spotify.Client {
+// Token returns the Spotify token of the signed in user, or nil when the
+// request is not authenticated.
+func (a *Auth) Token(r *http.Request) *oauth2.Token {
 	_, claims, err := jwtauth.FromContext(r.Context())
 	if err != nil {
 		return nil
@@ -112,8 +164,59 @@ func (a *Auth) GetSpotifyClient(r *http.Request) *spotify.Client {
 	if !ok {
 		return nil
 	}
-	token := utils.OAuthTokenFromInterface(spotifyToken)
-	return spotify.New(a.auth.Client(r.Context(), token))
+	return utils.OAuthTokenFromInterface(spotifyToken)
+}
+
+func (a *Auth) GetSpotifyClient(r *http.Request) *spotify.Client {
+	token := a.Token(r)
+	if token == nil {
+		return nil
+	}
+	return a.ClientForToken(r.Context(), token)
+}
+
+// ClientForToken returns a Spotify client for a token held outside of a
+// request, e.g. by the history collector. Its calls are rate limited as the
+// same user as their requests.
+func (a *Auth) ClientForToken(ctx context.Context, token *oauth2.Token) *spotify.Client {
+	if a.transport != nil {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{
+			Transport: a.transport.ForUser(tokenKey(token)),
+		})
+	}
+	var opts []spotify.ClientOption
+	if a.apiURL != "" {
+		opts = append(opts, spotify.WithBaseURL(a.apiURL))
+	}
+	return spotify.New(a.auth.Client(ctx, token), opts...)
+}
+
+// TokenSource returns token until it expires, and refreshed tokens after.
+func (a *Auth) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return a.auth.TokenSource(ctx, token)
+}
+
+// UserKey returns a stable, opaque identifier for the signed in user, or an
+// empty string when the request is not authenticated.
+func (a *Auth) UserKey(r *http.Request) string {
+	token := a.Token(r)
+	if token == nil {
+		return ""
+	}
+	return tokenKey(token)
+}
+
+func tokenKey(token *oauth2.Token) string {
+	secret := token.RefreshToken
+	if secret == "" {
+		secret = token.AccessToken
+	}
+	if secret == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:16])
 }
 
 func (a *Auth) CallbackHandler(w http.ResponseWriter, r *http.Request) {
@@ -131,12 +234,12 @@ func (a *Auth) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "state",
 		Value:    "",
-		Path:     "/",
+		Path:     utils.CookiePath(r.Context()),
 		HttpOnly: true,
 		MaxAge:   -1,
 	})
 
-	token, err := a.auth.Token(r.Context(), state, r)
+	token, err := a.auth.Token(r.Context(), state, r, a.redirectURLOptions(r)...)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Couldn't get token", http.StatusNotFound)
@@ -146,10 +249,10 @@ func (a *Auth) CallbackHandler(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "jwt",
 		Value:    tokenString,
-		Path:     "/",
+		Path:     utils.CookiePath(r.Context()),
 		HttpOnly: true,
 	})
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, utils.Path(r.Context(), "/"), http.StatusTemporaryRedirect)
 
 }
 
@@ -164,12 +267,12 @@ func (a *Auth) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "state",
 		Value:    state,
-		Path:     "/",
+		Path:     utils.CookiePath(r.Context()),
 		HttpOnly: true,
 		MaxAge:   int((time.Minute * 5) / time.Second),
 	})
 
-	url := a.auth.AuthURL(state)
+	url := a.auth.AuthURL(state, a.redirectURLOptions(r)...)
 
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
@@ -178,9 +281,9 @@ func (a *Auth) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "jwt",
 		Value:    "",
-		Path:     "/",
+		Path:     utils.CookiePath(r.Context()),
 		HttpOnly: true,
 		MaxAge:   -1,
 	})
-	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	http.Redirect(w, r, utils.Path(r.Context(), "/"), http.StatusTemporaryRedirect)
 }
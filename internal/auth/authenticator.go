@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+// Authenticator performs the Spotify OAuth2 authorization code flow. It
+// mirrors spotifyauth.Authenticator but lets the accounts service URL be
+// changed, so the app can run against a fake Spotify.
+type Authenticator struct {
+	config *oauth2.Config
+}
+
+type AuthenticatorOption func(*Authenticator)
+
+// WithAccountsURL points the authorize and token endpoints at another host,
+// e.g. "http://localhost:9090".
+func WithAccountsURL(accountsURL string) AuthenticatorOption {
+	return func(a *Authenticator) {
+		accountsURL = strings.TrimSuffix(accountsURL, "/")
+		a.config.Endpoint = oauth2.Endpoint{
+			AuthURL:  accountsURL + "/authorize",
+			TokenURL: accountsURL + "/api/token",
+		}
+	}
+}
+
+func NewAuthenticator(redirectURL string, clientID string, clientSecret string, opts ...AuthenticatorOption) *Authenticator {
+	a := &Authenticator{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  spotifyauth.AuthURL,
+				TokenURL: spotifyauth.TokenURL,
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+func (a *Authenticator) AuthURL(state string, opts ...oauth2.AuthCodeOption) string {
+	return a.config.AuthCodeURL(state, opts...)
+}
+
+// Token pulls the authorization code out of the callback request, checks the
+// state and exchanges the code for a token.
+func (a *Authenticator) Token(ctx context.Context, state string, r *http.Request, opts ...oauth2.AuthCodeOption) (*oauth2.Token, error) {
+	values := r.URL.Query()
+	if e := values.Get("error"); e != "" {
+		return nil, errors.New("spotify: auth failed - " + e)
+	}
+	code := values.Get("code")
+	if code == "" {
+		return nil, errors.New("spotify: didn't get access code")
+	}
+	if actualState := values.Get("state"); actualState != state {
+		return nil, errors.New("spotify: redirect state parameter doesn't match")
+	}
+	return a.config.Exchange(ctx, code, opts...)
+}
+
+func (a *Authenticator) Client(ctx context.Context, token *oauth2.Token) *http.Client {
+	return a.config.Client(ctx, token)
+}
+
+func (a *Authenticator) TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource {
+	return a.config.TokenSource(ctx, token)
+}
@@ -2,16 +2,47 @@ package config
 
 import (
 	"crypto/rand"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thattomperson/spotifgo/internal/utils"
 )
 
 type Config struct {
-	Port                string
-	Host                string
-	SpotifyClientID     string
-	SpotifyClientSecret string
-	SpotifyRedirectURL  string
-	TokenSecret         string
+	Port                 string
+	Host                 string
+	SpotifyClientID      string
+	SpotifyClientSecret  string
+	SpotifyRedirectURL   string
+	SpotifyAPIURL        string
+	SpotifyAccountsURL   string
+	TokenSecret          string
+	BasePath             string
+	TrustedProxies       []string
+	RpcRateLimit         float64
+	RpcRateBurst         int
+	SpotifyRateLimit     float64
+	SpotifyRateBurst     int
+	SpotifyUserRateLimit float64
+	SpotifyUserRateBurst int
+	PlaylistDedupeISRC   bool
+	TopTracksLimit       int
+	TopArtistsLimit      int
+	// DatabasePath is the SQLite database preferences and listening history
+	// are kept in. While it's empty preferences only last until a restart and
+	// collecting history is off. The file is created readable by its owner
+	// only, and the Spotify logins in it are encrypted with TokenSecret, so
+	// TOKEN_SECRET must stay the same across restarts or everyone has to opt
+	// in to history again.
+	DatabasePath    string
+	HistoryInterval time.Duration
+	// AdminAddr is where operator endpoints such as /debug/vars listen, e.g.
+	// "localhost:6060". They are off while it's empty, and it should never be
+	// reachable from the internet.
+	AdminAddr string
 }
 
 func NewConfig() *Config {
@@ -26,9 +57,35 @@ func (c *Config) Load() {
 	c.SpotifyClientID = os.Getenv("SPOTIFY_CLIENT_ID")
 	c.SpotifyClientSecret = os.Getenv("SPOTIFY_CLIENT_SECRET")
 	c.SpotifyRedirectURL = os.Getenv("SPOTIFY_REDIRECT_URL")
+	c.SpotifyAPIURL = os.Getenv("SPOTIFY_API_URL")
+	c.SpotifyAccountsURL = os.Getenv("SPOTIFY_ACCOUNTS_URL")
 	c.TokenSecret = os.Getenv("TOKEN_SECRET")
+	c.BasePath = utils.NormalizeBasePath(os.Getenv("BASE_PATH"))
+
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		c.TrustedProxies = strings.Split(proxies, ",")
+	}
+
+	c.RpcRateLimit = parseEnv("RPC_RATE_LIMIT", parseFloat, 0)
+	c.RpcRateBurst = parseEnv("RPC_RATE_BURST", strconv.Atoi, 0)
+	c.SpotifyRateLimit = parseEnv("SPOTIFY_RATE_LIMIT", parseFloat, 0)
+	c.SpotifyRateBurst = parseEnv("SPOTIFY_RATE_BURST", strconv.Atoi, 0)
+	c.SpotifyUserRateLimit = parseEnv("SPOTIFY_USER_RATE_LIMIT", parseFloat, 0)
+	c.SpotifyUserRateBurst = parseEnv("SPOTIFY_USER_RATE_BURST", strconv.Atoi, 0)
+
+	c.TopTracksLimit = parseEnv("TOP_TRACKS_LIMIT", strconv.Atoi, 0)
+	c.TopArtistsLimit = parseEnv("TOP_ARTISTS_LIMIT", strconv.Atoi, 0)
+
+	c.AdminAddr = os.Getenv("ADMIN_ADDR")
+	c.DatabasePath = os.Getenv("DATABASE_PATH")
+	c.HistoryInterval = parseEnv("HISTORY_INTERVAL", time.ParseDuration, 0)
+
+	c.PlaylistDedupeISRC = parseEnv("PLAYLIST_DEDUPE_ISRC", strconv.ParseBool, true)
 
 	if c.TokenSecret == "" {
+		if c.DatabasePath != "" {
+			log.Printf("TOKEN_SECRET is unset, stored history logins won't survive a restart")
+		}
 		c.TokenSecret = rand.Text()
 	}
 
@@ -36,18 +93,75 @@ func (c *Config) Load() {
 		c.Port = "8080"
 	}
 
+	if c.RpcRateLimit <= 0 {
+		c.RpcRateLimit = 2
+	}
+
+	if c.RpcRateBurst <= 0 {
+		c.RpcRateBurst = 10
+	}
+
+	if c.SpotifyRateLimit <= 0 {
+		c.SpotifyRateLimit = 20
+	}
+
+	if c.SpotifyRateBurst <= 0 {
+		c.SpotifyRateBurst = 40
+	}
+
+	if c.SpotifyUserRateLimit <= 0 {
+		c.SpotifyUserRateLimit = 5
+	}
+
+	if c.SpotifyUserRateBurst <= 0 {
+		c.SpotifyUserRateBurst = 20
+	}
+
+	if c.TopTracksLimit <= 0 {
+		c.TopTracksLimit = 50
+	}
+
+	if c.TopArtistsLimit <= 0 {
+		c.TopArtistsLimit = 20
+	}
+
+	if c.HistoryInterval <= 0 {
+		c.HistoryInterval = 30 * time.Minute
+	}
+
 	if c.Host == "" {
 		c.Host = "http://localhost:" + c.Port
 	}
 
 	if c.SpotifyRedirectURL == "" {
-		c.SpotifyRedirectURL = c.Host + "/auth/callback"
+		c.SpotifyRedirectURL = c.Host + c.BasePath + "/auth/callback"
+	}
+}
+
+// parseEnv parses the environment variable name, returning fallback when it
+// is unset. Values that don't parse are logged rather than silently replaced
+// by the fallback.
+func parseEnv[T any](name string, parse func(string) (T, error), fallback T) T {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
 	}
+	parsed, err := parse(value)
+	if err != nil {
+		log.Printf("Ignoring invalid %s=%q: %v", name, value, err)
+		return fallback
+	}
+	return parsed
+}
+
+func parseFloat(value string) (float64, error) {
+	return strconv.ParseFloat(value, 64)
 }
 
 func (c *Config) Sanitized() *Config {
 	return &Config{
-		Port: c.Port,
-		Host: c.Host,
+		Port:     c.Port,
+		Host:     c.Host,
+		BasePath: c.BasePath,
 	}
 }
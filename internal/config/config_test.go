@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadFallsBackOnInvalidValues(t *testing.T) {
+	t.Setenv("RPC_RATE_LIMIT", "fast")
+	t.Setenv("RPC_RATE_BURST", "12")
+	t.Setenv("HISTORY_INTERVAL", "30")
+	t.Setenv("PLAYLIST_DEDUPE_ISRC", "nope")
+
+	c := NewConfig()
+	if c.RpcRateLimit != 2 {
+		t.Errorf("RpcRateLimit: got %v, want the default 2", c.RpcRateLimit)
+	}
+	if c.RpcRateBurst != 12 {
+		t.Errorf("RpcRateBurst: got %v, want 12", c.RpcRateBurst)
+	}
+	if c.HistoryInterval != 30*time.Minute {
+		t.Errorf("HistoryInterval: got %v, want the default 30m", c.HistoryInterval)
+	}
+	if !c.PlaylistDedupeISRC {
+		t.Errorf("PlaylistDedupeISRC: got false, want the default true")
+	}
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"io/fs"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// Open opens, and if needed creates, the SQLite database at path. A new file
+// is only readable by its owner, as stores keep Spotify logins in it. SQLite
+// gives the WAL and shared memory files the same permissions. An empty path
+// opens a database in memory, gone once it's closed.
+func Open(path string) (*sql.DB, error) {
+	if path == "" {
+		return open("file::memory:")
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600)
+	if err == nil {
+		file.Close()
+	} else if !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+
+	return open("file:" + path)
+}
+
+func open(name string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", name+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, so share a single connection
+	// rather than fail with SQLITE_BUSY under concurrent writes. In memory
+	// it's also the only connection that sees the database.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
@@ -0,0 +1,856 @@
+package fakespotify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zmb3/spotify/v2"
+)
+
+func (s *Server) apiRoutes(r chi.Router) {
+	r.Get("/me", s.me)
+	r.Get("/me/top/tracks", s.topTracks)
+	r.Get("/me/top/artists", s.topArtists)
+	r.Get("/me/tracks", s.savedTracks)
+	r.Get("/me/tracks/contains", s.savedTracksContains)
+	r.Put("/me/tracks", s.saveTracks)
+	r.Delete("/me/tracks", s.removeSavedTracks)
+	r.Get("/me/playlists", s.myPlaylists)
+
+	r.Get("/me/player", s.playerState)
+	r.Put("/me/player", s.transferPlayback)
+	r.Get("/me/player/currently-playing", s.currentlyPlaying)
+	r.Get("/me/player/recently-played", s.recentlyPlayed)
+	r.Get("/me/player/devices", s.devices)
+	r.Get("/me/player/queue", s.queue)
+	r.Post("/me/player/queue", s.addToQueue)
+	r.Put("/me/player/play", s.play)
+	r.Put("/me/player/pause", s.pause)
+	r.Post("/me/player/next", s.next)
+	r.Post("/me/player/previous", s.previous)
+	r.Put("/me/player/seek", s.seek)
+	r.Put("/me/player/shuffle", s.shuffle)
+	r.Put("/me/player/repeat", s.repeat)
+	r.Put("/me/player/volume", s.volume)
+
+	r.Get("/tracks", s.tracks)
+	r.Get("/tracks/{id}", s.track)
+	r.Get("/artists", s.artists)
+	r.Get("/artists/{id}", s.artist)
+	r.Get("/artists/{id}/top-tracks", s.artistTopTracks)
+	r.Get("/artists/{id}/related-artists", s.relatedArtists)
+	r.Get("/artists/{id}/albums", s.artistAlbums)
+	r.Get("/albums/{id}", s.album)
+	r.Get("/albums/{id}/tracks", s.albumTracks)
+
+	r.Get("/playlists/{id}", s.playlist)
+	r.Get("/playlists/{id}/tracks", s.playlistItems)
+	r.Post("/playlists/{id}/tracks", s.addPlaylistItems)
+	r.Post("/users/{user}/playlists", s.createPlaylist)
+
+	r.Get("/recommendations", s.recommendations)
+	r.Get("/search", s.search)
+}
+
+// lock takes the state lock for the rest of a handler.
+func (s *Server) lock() func() {
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *Server) me(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	writeJSON(w, http.StatusOK, s.state.User)
+}
+
+// topForRange returns the top items for the request's time_range, falling
+// back to the default ones.
+func topForRange(r *http.Request, byRange map[string][]spotify.ID, fallback []spotify.ID) []spotify.ID {
+	if ids, ok := byRange[r.URL.Query().Get("time_range")]; ok {
+		return ids
+	}
+	return fallback
+}
+
+func (s *Server) topTracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	writeJSON(w, http.StatusOK, page(r, s.fullTracks(topForRange(r, s.state.TopTracksByRange, s.state.TopTracks)), 20, 50))
+}
+
+func (s *Server) topArtists(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	var artists []spotify.FullArtist
+	for _, id := range topForRange(r, s.state.TopArtistsByRange, s.state.TopArtists) {
+		if artist, ok := s.state.Artists[id]; ok {
+			artists = append(artists, artist)
+		}
+	}
+	writeJSON(w, http.StatusOK, page(r, artists, 20, 50))
+}
+
+func (s *Server) savedTracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	var saved []spotify.SavedTrack
+	for _, item := range s.state.SavedTracks {
+		if track, ok := s.state.Tracks[item.TrackID]; ok {
+			saved = append(saved, spotify.SavedTrack{AddedAt: item.AddedAt.UTC().Format(spotify.TimestampLayout), FullTrack: track})
+		}
+	}
+	writeJSON(w, http.StatusOK, page(r, saved, 20, 50))
+}
+
+func (s *Server) savedTracksContains(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	ids := idsParam(r)
+	contains := make([]bool, len(ids))
+	for i, id := range ids {
+		contains[i] = slices.ContainsFunc(s.state.SavedTracks, func(saved Saved) bool {
+			return saved.TrackID == spotify.ID(id)
+		})
+	}
+	writeJSON(w, http.StatusOK, contains)
+}
+
+func (s *Server) saveTracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	for _, id := range idsParam(r) {
+		if _, ok := s.state.Tracks[spotify.ID(id)]; !ok {
+			writeError(w, http.StatusBadRequest, "Invalid id: "+id)
+			return
+		}
+		if !slices.ContainsFunc(s.state.SavedTracks, func(saved Saved) bool { return saved.TrackID == spotify.ID(id) }) {
+			s.state.SavedTracks = append([]Saved{{TrackID: spotify.ID(id), AddedAt: time.Now()}}, s.state.SavedTracks...)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) removeSavedTracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	ids := idsParam(r)
+	s.state.SavedTracks = slices.DeleteFunc(s.state.SavedTracks, func(saved Saved) bool {
+		return slices.Contains(ids, saved.TrackID.String())
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) myPlaylists(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	playlists := make([]spotify.SimplePlaylist, 0, len(s.state.Playlists))
+	for _, playlist := range s.state.Playlists {
+		playlists = append(playlists, s.simplePlaylist(playlist))
+	}
+	writeJSON(w, http.StatusOK, page(r, playlists, 20, 50))
+}
+
+// activeDevice finds the device a player command targets, writing the same
+// errors Spotify does when there is none or the user isn't premium.
+func (s *Server) activeDevice(w http.ResponseWriter, r *http.Request) *spotify.PlayerDevice {
+	if s.state.User.Product != "premium" {
+		writePlayerError(w, http.StatusForbidden, "Player command failed: Premium required", "PREMIUM_REQUIRED")
+		return nil
+	}
+	if deviceID := r.URL.Query().Get("device_id"); deviceID != "" {
+		for i := range s.state.Player.Devices {
+			if s.state.Player.Devices[i].ID == spotify.ID(deviceID) {
+				return &s.state.Player.Devices[i]
+			}
+		}
+		writePlayerError(w, http.StatusNotFound, "Device not found", "DEVICE_NOT_FOUND")
+		return nil
+	}
+	device := s.state.Player.ActiveDevice()
+	if device == nil {
+		writePlayerError(w, http.StatusNotFound, "Player command failed: No active device found", "NO_ACTIVE_DEVICE")
+		return nil
+	}
+	return device
+}
+
+func (s *Server) currentlyPlayingObject() spotify.CurrentlyPlaying {
+	player := s.state.Player
+	current := spotify.CurrentlyPlaying{
+		Timestamp: time.Now().UnixMilli(),
+		Progress:  spotify.Numeric(player.ProgressMs),
+		Playing:   player.Playing,
+	}
+	if player.ContextURI != "" {
+		parts := strings.Split(string(player.ContextURI), ":")
+		current.PlaybackContext = spotify.PlaybackContext{URI: player.ContextURI, Type: parts[min(1, len(parts)-1)]}
+	}
+	if track, ok := s.state.Tracks[player.TrackID]; ok {
+		current.Item = &track
+	}
+	return current
+}
+
+func (s *Server) playerState(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	device := s.state.Player.ActiveDevice()
+	if device == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, spotify.PlayerState{
+		CurrentlyPlaying: s.currentlyPlayingObject(),
+		Device:           *device,
+		ShuffleState:     s.state.Player.Shuffle,
+		RepeatState:      s.state.Player.Repeat,
+	})
+}
+
+func (s *Server) currentlyPlaying(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.state.Player.TrackID == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.currentlyPlayingObject())
+}
+
+func (s *Server) recentlyPlayed(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	limit, _ := pageParams(r, 20, 50)
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+
+	items := []spotify.RecentlyPlayedItem{}
+	for _, play := range s.state.RecentlyPlayed {
+		playedAt := play.PlayedAt.UnixMilli()
+		if (before != 0 && playedAt >= before) || (after != 0 && playedAt <= after) {
+			continue
+		}
+		track, ok := s.state.Tracks[play.TrackID]
+		if !ok {
+			continue
+		}
+		simple := track.SimpleTrack
+		simple.Album = track.Album
+		items = append(items, spotify.RecentlyPlayedItem{Track: simple, PlayedAt: play.PlayedAt})
+		if len(items) >= limit {
+			break
+		}
+	}
+
+	cursors := map[string]string{}
+	if len(items) > 0 {
+		cursors["after"] = strconv.FormatInt(items[0].PlayedAt.UnixMilli(), 10)
+		cursors["before"] = strconv.FormatInt(items[len(items)-1].PlayedAt.UnixMilli(), 10)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"href":    r.URL.String(),
+		"items":   items,
+		"limit":   limit,
+		"cursors": cursors,
+	})
+}
+
+func (s *Server) devices(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	devices := s.state.Player.Devices
+	if devices == nil {
+		devices = []spotify.PlayerDevice{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"devices": devices})
+}
+
+func (s *Server) queue(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	queue := spotify.Queue{Items: s.fullTracks(s.state.Queue)}
+	if track, ok := s.state.Tracks[s.state.Player.TrackID]; ok {
+		queue.CurrentlyPlaying = track
+	}
+	writeJSON(w, http.StatusOK, queue)
+}
+
+func (s *Server) addToQueue(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	id, ok := strings.CutPrefix(r.URL.Query().Get("uri"), "spotify:track:")
+	if _, exists := s.state.Tracks[spotify.ID(id)]; !ok || !exists {
+		writeError(w, http.StatusBadRequest, "Invalid track uri")
+		return
+	}
+	s.state.Queue = append(s.state.Queue, spotify.ID(id))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) transferPlayback(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	var body struct {
+		DeviceIDs []spotify.ID `json:"device_ids"`
+		Play      bool         `json:"play"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.DeviceIDs) == 0 {
+		writeError(w, http.StatusBadRequest, "Missing device_ids")
+		return
+	}
+
+	if !slices.ContainsFunc(s.state.Player.Devices, func(device spotify.PlayerDevice) bool {
+		return device.ID == body.DeviceIDs[0]
+	}) {
+		writePlayerError(w, http.StatusNotFound, "Device not found", "DEVICE_NOT_FOUND")
+		return
+	}
+	for i := range s.state.Player.Devices {
+		device := &s.state.Player.Devices[i]
+		device.Active = device.ID == body.DeviceIDs[0]
+	}
+	if body.Play {
+		s.state.Player.Playing = true
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) play(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	device := s.activeDevice(w, r)
+	if device == nil {
+		return
+	}
+	for i := range s.state.Player.Devices {
+		s.state.Player.Devices[i].Active = s.state.Player.Devices[i].ID == device.ID
+	}
+
+	var body spotify.PlayOptions
+	if r.ContentLength != 0 {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch {
+	case len(body.URIs) > 0:
+		id, _ := strings.CutPrefix(string(body.URIs[0]), "spotify:track:")
+		s.state.Play(spotify.ID(id), "")
+	case body.PlaybackContext != nil:
+		if trackIDs := s.contextTracks(*body.PlaybackContext); len(trackIDs) > 0 {
+			start := 0
+			if body.PlaybackOffset != nil && body.PlaybackOffset.Position != nil {
+				start = min(*body.PlaybackOffset.Position, len(trackIDs)-1)
+			}
+			s.state.Play(trackIDs[start], *body.PlaybackContext)
+		}
+	default:
+		s.state.Player.Playing = true
+	}
+	if body.PositionMs > 0 {
+		s.state.Player.ProgressMs = int(body.PositionMs)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) pause(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	s.state.Player.Playing = false
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) next(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	if len(s.state.Queue) > 0 {
+		next := s.state.Queue[0]
+		s.state.Queue = s.state.Queue[1:]
+		s.state.Play(next, s.state.Player.ContextURI)
+	} else if trackIDs := s.contextTracks(s.state.Player.ContextURI); len(trackIDs) > 0 {
+		index := slices.Index(trackIDs, s.state.Player.TrackID)
+		s.state.Play(trackIDs[(index+1)%len(trackIDs)], s.state.Player.ContextURI)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) previous(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	if len(s.state.RecentlyPlayed) > 0 {
+		previous := s.state.RecentlyPlayed[0]
+		s.state.RecentlyPlayed = s.state.RecentlyPlayed[1:]
+		s.state.Player.TrackID = previous.TrackID
+		s.state.Player.ProgressMs = 0
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) seek(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	position, err := strconv.Atoi(r.URL.Query().Get("position_ms"))
+	if err != nil || position < 0 {
+		writeError(w, http.StatusBadRequest, "Invalid position_ms")
+		return
+	}
+	s.state.Player.ProgressMs = position
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) shuffle(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	state, err := strconv.ParseBool(r.URL.Query().Get("state"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid state")
+		return
+	}
+	s.state.Player.Shuffle = state
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) repeat(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.activeDevice(w, r) == nil {
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state != "off" && state != "track" && state != "context" {
+		writeError(w, http.StatusBadRequest, "Invalid state")
+		return
+	}
+	s.state.Player.Repeat = state
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) volume(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	device := s.activeDevice(w, r)
+	if device == nil {
+		return
+	}
+	volume, err := strconv.Atoi(r.URL.Query().Get("volume_percent"))
+	if err != nil || volume < 0 || volume > 100 {
+		writeError(w, http.StatusBadRequest, "Invalid volume_percent")
+		return
+	}
+	device.Volume = spotify.Numeric(volume)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) tracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	ids := idsParam(r)
+	if len(ids) > 50 {
+		writeError(w, http.StatusBadRequest, "Too many ids requested")
+		return
+	}
+	tracks := make([]*spotify.FullTrack, len(ids))
+	for i, id := range ids {
+		if track, ok := s.state.Tracks[spotify.ID(id)]; ok {
+			tracks[i] = &track
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": tracks})
+}
+
+func (s *Server) track(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	track, ok := s.state.Tracks[spotify.ID(chi.URLParam(r, "id"))]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid base62 id")
+		return
+	}
+	writeJSON(w, http.StatusOK, track)
+}
+
+func (s *Server) artists(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	ids := idsParam(r)
+	artists := make([]*spotify.FullArtist, len(ids))
+	for i, id := range ids {
+		if artist, ok := s.state.Artists[spotify.ID(id)]; ok {
+			artists[i] = &artist
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"artists": artists})
+}
+
+func (s *Server) artist(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	artist, ok := s.state.Artists[spotify.ID(chi.URLParam(r, "id"))]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid base62 id")
+		return
+	}
+	writeJSON(w, http.StatusOK, artist)
+}
+
+// artistTracks returns an artist's tracks, most popular first.
+func (s *Server) artistTracks(artistID spotify.ID) []spotify.FullTrack {
+	var tracks []spotify.FullTrack
+	for _, track := range s.state.Tracks {
+		if len(track.Artists) > 0 && track.Artists[0].ID == artistID {
+			tracks = append(tracks, track)
+		}
+	}
+	slices.SortFunc(tracks, func(a, b spotify.FullTrack) int {
+		if a.Popularity != b.Popularity {
+			return int(b.Popularity) - int(a.Popularity)
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	return tracks
+}
+
+func (s *Server) artistTopTracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	tracks := s.artistTracks(spotify.ID(chi.URLParam(r, "id")))
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": tracks[:min(len(tracks), 10)]})
+}
+
+func (s *Server) relatedArtists(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	artists := []spotify.FullArtist{}
+	for _, id := range s.state.RelatedArtists[spotify.ID(chi.URLParam(r, "id"))] {
+		if artist, ok := s.state.Artists[id]; ok {
+			artists = append(artists, artist)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"artists": artists})
+}
+
+func (s *Server) artistAlbums(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	artistID := spotify.ID(chi.URLParam(r, "id"))
+	var groups []string
+	if includeGroups := r.URL.Query().Get("include_groups"); includeGroups != "" {
+		groups = strings.Split(includeGroups, ",")
+	}
+
+	var albums []spotify.SimpleAlbum
+	for _, album := range s.state.Albums {
+		if len(album.Artists) == 0 || album.Artists[0].ID != artistID {
+			continue
+		}
+		if groups != nil && !slices.Contains(groups, album.AlbumGroup) {
+			continue
+		}
+		albums = append(albums, album.SimpleAlbum)
+	}
+	slices.SortFunc(albums, func(a, b spotify.SimpleAlbum) int {
+		return strings.Compare(b.ReleaseDate, a.ReleaseDate)
+	})
+	writeJSON(w, http.StatusOK, page(r, albums, 20, 50))
+}
+
+// albumTrackList returns an album's tracks in disc and track order.
+func (s *Server) albumTrackList(albumID spotify.ID) []spotify.SimpleTrack {
+	var tracks []spotify.SimpleTrack
+	for _, track := range s.state.Tracks {
+		if track.Album.ID == albumID {
+			tracks = append(tracks, track.SimpleTrack)
+		}
+	}
+	slices.SortFunc(tracks, func(a, b spotify.SimpleTrack) int {
+		if a.DiscNumber != b.DiscNumber {
+			return int(a.DiscNumber) - int(b.DiscNumber)
+		}
+		return int(a.TrackNumber) - int(b.TrackNumber)
+	})
+	return tracks
+}
+
+func (s *Server) album(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	album, ok := s.state.Albums[spotify.ID(chi.URLParam(r, "id"))]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid base62 id")
+		return
+	}
+	tracks := s.albumTrackList(album.ID)
+	album.Tracks.Tracks = tracks
+	album.Tracks.Total = spotify.Numeric(len(tracks))
+	album.Tracks.Limit = 50
+	writeJSON(w, http.StatusOK, album)
+}
+
+func (s *Server) albumTracks(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	albumID := spotify.ID(chi.URLParam(r, "id"))
+	if _, ok := s.state.Albums[albumID]; !ok {
+		writeError(w, http.StatusBadRequest, "Invalid base62 id")
+		return
+	}
+	writeJSON(w, http.StatusOK, page(r, s.albumTrackList(albumID), 20, 50))
+}
+
+func (s *Server) simplePlaylist(playlist *Playlist) spotify.SimplePlaylist {
+	return spotify.SimplePlaylist{
+		ID:            playlist.ID,
+		Name:          playlist.Name,
+		Description:   playlist.Description,
+		Collaborative: playlist.Collaborative,
+		IsPublic:      playlist.Public,
+		Owner:         spotify.User{ID: playlist.OwnerID, DisplayName: playlist.OwnerID},
+		SnapshotID:    fmt.Sprintf("snapshot-%d", playlist.Snapshot),
+		Tracks:        spotify.PlaylistTracks{Total: spotify.Numeric(len(playlist.Items))},
+		URI:           spotify.URI("spotify:playlist:" + playlist.ID),
+		ExternalURLs:  map[string]string{"spotify": "https://open.spotify.com/playlist/" + playlist.ID.String()},
+	}
+}
+
+// playlistItem is a playlist item as Spotify sends it, the zmb3 type only
+// knows how to decode it.
+type playlistItem struct {
+	AddedAt string            `json:"added_at"`
+	AddedBy spotify.User      `json:"added_by"`
+	IsLocal bool              `json:"is_local"`
+	Track   spotify.FullTrack `json:"track"`
+}
+
+func (s *Server) playlistItemsOf(playlist *Playlist) []playlistItem {
+	items := []playlistItem{}
+	for _, item := range playlist.Items {
+		track, ok := s.state.Tracks[item.TrackID]
+		if !ok {
+			continue
+		}
+		items = append(items, playlistItem{
+			AddedAt: item.AddedAt.UTC().Format(spotify.TimestampLayout),
+			AddedBy: spotify.User{ID: playlist.OwnerID},
+			Track:   track,
+		})
+	}
+	return items
+}
+
+func (s *Server) playlist(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	playlist := s.state.Playlist(spotify.ID(chi.URLParam(r, "id")))
+	if playlist == nil {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"collaborative": playlist.Collaborative,
+		"description":   playlist.Description,
+		"id":            playlist.ID,
+		"name":          playlist.Name,
+		"owner":         spotify.User{ID: playlist.OwnerID, DisplayName: playlist.OwnerID},
+		"public":        playlist.Public,
+		"snapshot_id":   fmt.Sprintf("snapshot-%d", playlist.Snapshot),
+		"uri":           "spotify:playlist:" + playlist.ID,
+		"external_urls": map[string]string{"spotify": "https://open.spotify.com/playlist/" + playlist.ID.String()},
+		"followers":     spotify.Followers{},
+		"tracks":        page(r, s.playlistItemsOf(playlist), 100, 100),
+	})
+}
+
+func (s *Server) playlistItems(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	playlist := s.state.Playlist(spotify.ID(chi.URLParam(r, "id")))
+	if playlist == nil {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	writeJSON(w, http.StatusOK, page(r, s.playlistItemsOf(playlist), 100, 100))
+}
+
+func (s *Server) addPlaylistItems(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	playlist := s.state.Playlist(spotify.ID(chi.URLParam(r, "id")))
+	if playlist == nil {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	if playlist.OwnerID != s.state.User.ID && !playlist.Collaborative {
+		writeError(w, http.StatusForbidden, "You cannot add tracks to a playlist you don't own.")
+		return
+	}
+
+	var body struct {
+		URIs []string `json:"uris"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body.URIs) > 100 {
+		writeError(w, http.StatusBadRequest, "You can add a maximum of 100 tracks per request.")
+		return
+	}
+
+	var items []PlaylistItem
+	for _, uri := range body.URIs {
+		id, ok := strings.CutPrefix(uri, "spotify:track:")
+		if _, exists := s.state.Tracks[spotify.ID(id)]; !ok || !exists {
+			writeError(w, http.StatusBadRequest, "Invalid track uri: "+uri)
+			return
+		}
+		items = append(items, PlaylistItem{TrackID: spotify.ID(id), AddedAt: time.Now()})
+	}
+
+	playlist.Items = append(playlist.Items, items...)
+	playlist.Snapshot++
+	writeJSON(w, http.StatusCreated, map[string]string{"snapshot_id": fmt.Sprintf("snapshot-%d", playlist.Snapshot)})
+}
+
+func (s *Server) createPlaylist(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if chi.URLParam(r, "user") != s.state.User.ID {
+		writeError(w, http.StatusForbidden, "You cannot create a playlist for another user")
+		return
+	}
+
+	var body struct {
+		Name          string `json:"name"`
+		Public        bool   `json:"public"`
+		Description   string `json:"description"`
+		Collaborative bool   `json:"collaborative"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing required field: name")
+		return
+	}
+
+	playlist := s.state.AddPlaylist(spotify.ID(fmt.Sprintf("playlist%02d", len(s.state.Playlists)+1)), body.Name, s.state.User.ID)
+	playlist.Description = body.Description
+	playlist.Public = body.Public
+	playlist.Collaborative = body.Collaborative
+	// New playlists show up first, like they do on Spotify.
+	s.state.Playlists = append([]*Playlist{playlist}, s.state.Playlists[:len(s.state.Playlists)-1]...)
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":            playlist.ID,
+		"name":          playlist.Name,
+		"description":   playlist.Description,
+		"public":        playlist.Public,
+		"collaborative": playlist.Collaborative,
+		"owner":         spotify.User{ID: playlist.OwnerID},
+		"uri":           "spotify:playlist:" + playlist.ID,
+		"external_urls": map[string]string{"spotify": "https://open.spotify.com/playlist/" + playlist.ID.String()},
+		"tracks":        page(r, []playlistItem{}, 100, 100),
+	})
+}
+
+func (s *Server) recommendations(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	if s.state.RecommendationsDisabled {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	limit, _ := pageParams(r, 20, 100)
+	seeds := strings.Split(r.URL.Query().Get("seed_tracks"), ",")
+
+	tracks := []spotify.SimpleTrack{}
+	for _, seed := range seeds {
+		seedTrack, ok := s.state.Tracks[spotify.ID(seed)]
+		if !ok || len(seedTrack.Artists) == 0 {
+			continue
+		}
+		related := append([]spotify.ID{seedTrack.Artists[0].ID}, s.state.RelatedArtists[seedTrack.Artists[0].ID]...)
+		for _, artistID := range related {
+			for _, track := range s.artistTracks(artistID) {
+				if track.ID == seedTrack.ID || len(tracks) >= limit {
+					continue
+				}
+				simple := track.SimpleTrack
+				simple.Album = track.Album
+				tracks = append(tracks, simple)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"seeds": []any{}, "tracks": tracks})
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	defer s.lock()()
+	query := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, "No search query")
+		return
+	}
+	matches := func(name string) bool {
+		return strings.Contains(strings.ToLower(name), query)
+	}
+
+	result := map[string]any{}
+	for _, searchType := range strings.Split(r.URL.Query().Get("type"), ",") {
+		switch searchType {
+		case "track":
+			var tracks []spotify.FullTrack
+			for _, track := range s.state.Tracks {
+				if matches(track.Name) || (len(track.Artists) > 0 && matches(track.Artists[0].Name)) {
+					tracks = append(tracks, track)
+				}
+			}
+			slices.SortFunc(tracks, func(a, b spotify.FullTrack) int { return strings.Compare(a.ID.String(), b.ID.String()) })
+			result["tracks"] = page(r, tracks, 20, 50)
+		case "artist":
+			var artists []spotify.FullArtist
+			for _, artist := range s.state.Artists {
+				if matches(artist.Name) {
+					artists = append(artists, artist)
+				}
+			}
+			slices.SortFunc(artists, func(a, b spotify.FullArtist) int { return strings.Compare(a.ID.String(), b.ID.String()) })
+			result["artists"] = page(r, artists, 20, 50)
+		case "album":
+			var albums []spotify.SimpleAlbum
+			for _, album := range s.state.Albums {
+				if matches(album.Name) {
+					albums = append(albums, album.SimpleAlbum)
+				}
+			}
+			slices.SortFunc(albums, func(a, b spotify.SimpleAlbum) int { return strings.Compare(a.ID.String(), b.ID.String()) })
+			result["albums"] = page(r, albums, 20, 50)
+		case "playlist":
+			var playlists []spotify.SimplePlaylist
+			for _, playlist := range s.state.Playlists {
+				if matches(playlist.Name) {
+					playlists = append(playlists, s.simplePlaylist(playlist))
+				}
+			}
+			result["playlists"] = page(r, playlists, 20, 50)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// contextTracks resolves a playlist or album URI to its track IDs.
+func (s *Server) contextTracks(uri spotify.URI) []spotify.ID {
+	parts := strings.Split(string(uri), ":")
+	if len(parts) != 3 {
+		return nil
+	}
+	var ids []spotify.ID
+	switch parts[1] {
+	case "playlist":
+		if playlist := s.state.Playlist(spotify.ID(parts[2])); playlist != nil {
+			for _, item := range playlist.Items {
+				ids = append(ids, item.TrackID)
+			}
+		}
+	case "album":
+		for _, track := range s.albumTrackList(spotify.ID(parts[2])) {
+			ids = append(ids, track.ID)
+		}
+	}
+	return ids
+}
+
+func (s *Server) fullTracks(ids []spotify.ID) []spotify.FullTrack {
+	tracks := []spotify.FullTrack{}
+	for _, id := range ids {
+		if track, ok := s.state.Tracks[id]; ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
@@ -0,0 +1,275 @@
+package fakespotify
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Server is an in-memory stand-in for the Spotify accounts service and the
+// parts of the Web API the app uses. Point SPOTIFY_ACCOUNTS_URL at the server
+// root and SPOTIFY_API_URL at its /v1/ path.
+type Server struct {
+	mu     sync.Mutex
+	state  *State
+	tokens map[string]bool
+	codes  map[string]bool
+	router chi.Router
+}
+
+type ServerOption func(*Server)
+
+// WithState starts the server with the given state instead of the seeded one.
+func WithState(state *State) ServerOption {
+	return func(s *Server) {
+		s.state = state
+	}
+}
+
+func New(opts ...ServerOption) *Server {
+	s := &Server{
+		state:  NewSeededState(),
+		tokens: map[string]bool{},
+		codes:  map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/authorize", s.authorize)
+	r.Post("/api/token", s.token)
+
+	r.Route("/_fake", func(r chi.Router) {
+		r.Get("/state", s.getState)
+		r.Put("/state", s.putState)
+		r.Post("/reset", s.reset)
+	})
+
+	r.Route("/v1", func(r chi.Router) {
+		r.Use(s.requireToken)
+		s.apiRoutes(r)
+	})
+
+	s.router = r
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Update runs fn with exclusive access to the state, for scripting tests.
+func (s *Server) Update(fn func(state *State)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.state)
+}
+
+// IssueToken returns a valid access token without going through the OAuth
+// dance.
+func (s *Server) IssueToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issueToken()
+}
+
+// RevokeTokens invalidates every access token handed out so far, as if they
+// had all expired.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.tokens)
+}
+
+func (s *Server) issueToken() string {
+	token := "fake-access-" + rand.Text()
+	s.tokens[token] = true
+	return token
+}
+
+// authorize skips the consent screen and sends the user straight back to the
+// app with a code.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	redirectURI, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+	if err != nil || redirectURI.String() == "" {
+		writeError(w, http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	code := "fake-code-" + rand.Text()
+	s.mu.Lock()
+	s.codes[code] = true
+	s.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", r.URL.Query().Get("state"))
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		if !s.codes[code] {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(s.codes, code)
+	case "refresh_token":
+		if !strings.HasPrefix(r.PostForm.Get("refresh_token"), "fake-refresh-") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token":  s.issueToken(),
+		"token_type":    "Bearer",
+		"expires_in":    int(time.Hour / time.Second),
+		"refresh_token": "fake-refresh-" + s.state.User.ID,
+		"scope":         r.PostForm.Get("scope"),
+	})
+}
+
+func (s *Server) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		s.mu.Lock()
+		valid := ok && s.tokens[token]
+		s.mu.Unlock()
+
+		if !valid {
+			writeError(w, http.StatusUnauthorized, "Invalid access token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) getState(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.state)
+}
+
+func (s *Server) putState(w http.ResponseWriter, r *http.Request) {
+	state := NewState("")
+	if err := json.NewDecoder(r.Body).Decode(state); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) reset(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.state = NewSeededState()
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeError responds with Spotify's regular error object.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"status":  status,
+			"message": message,
+		},
+	})
+}
+
+// writePlayerError responds with a player error that carries a reason, like
+// NO_ACTIVE_DEVICE or PREMIUM_REQUIRED.
+func writePlayerError(w http.ResponseWriter, status int, message string, reason string) {
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"status":  status,
+			"message": message,
+			"reason":  reason,
+		},
+	})
+}
+
+// pageParams reads limit and offset, clamping limit to max.
+func pageParams(r *http.Request, defaultLimit int, maxLimit int) (limit int, offset int) {
+	limit = defaultLimit
+	if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+		limit = min(value, maxLimit)
+	}
+	if value, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && value > 0 {
+		offset = value
+	}
+	return limit, offset
+}
+
+// page slices items and wraps them in Spotify's paging object.
+func page[T any](r *http.Request, items []T, defaultLimit int, maxLimit int) map[string]any {
+	limit, offset := pageParams(r, defaultLimit, maxLimit)
+	total := len(items)
+	start := min(offset, total)
+	end := min(offset+limit, total)
+
+	var next any
+	if end < total {
+		nextURL := *r.URL
+		query := nextURL.Query()
+		query.Set("offset", strconv.Itoa(end))
+		query.Set("limit", strconv.Itoa(limit))
+		nextURL.RawQuery = query.Encode()
+		next = nextURL.String()
+	}
+
+	pageItems := items[start:end]
+	if pageItems == nil {
+		pageItems = []T{}
+	}
+	return map[string]any{
+		"href":   r.URL.String(),
+		"items":  pageItems,
+		"limit":  limit,
+		"offset": offset,
+		"total":  total,
+		"next":   next,
+	}
+}
+
+// idsParam splits the comma separated ids query parameter.
+func idsParam(r *http.Request) []string {
+	value := r.URL.Query().Get("ids")
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
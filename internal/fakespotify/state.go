@@ -0,0 +1,273 @@
+package fakespotify
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// State is everything the fake knows about. It is plain data so tests can
+// build it directly and the control endpoint can read and replace it as JSON.
+type State struct {
+	User spotify.PrivateUser `json:"user"`
+
+	Tracks         map[spotify.ID]spotify.FullTrack  `json:"tracks"`
+	Artists        map[spotify.ID]spotify.FullArtist `json:"artists"`
+	Albums         map[spotify.ID]spotify.FullAlbum  `json:"albums"`
+	RelatedArtists map[spotify.ID][]spotify.ID       `json:"related_artists"`
+
+	Playlists []*Playlist `json:"playlists"`
+
+	Player         Player       `json:"player"`
+	Queue          []spotify.ID `json:"queue"`
+	RecentlyPlayed []Play       `json:"recently_played"`
+	SavedTracks    []Saved      `json:"saved_tracks"`
+	TopTracks      []spotify.ID `json:"top_tracks"`
+	TopArtists     []spotify.ID `json:"top_artists"`
+	// TopTracksByRange and TopArtistsByRange replace TopTracks and
+	// TopArtists for a time_range, e.g. short_term.
+	TopTracksByRange  map[string][]spotify.ID `json:"top_tracks_by_range"`
+	TopArtistsByRange map[string][]spotify.ID `json:"top_artists_by_range"`
+
+	// RecommendationsDisabled makes /recommendations fail like it does for
+	// apps created after Spotify deprecated it.
+	RecommendationsDisabled bool `json:"recommendations_disabled"`
+}
+
+type Playlist struct {
+	ID            spotify.ID     `json:"id"`
+	Name          string         `json:"name"`
+	Description   string         `json:"description"`
+	OwnerID       string         `json:"owner_id"`
+	Public        bool           `json:"public"`
+	Collaborative bool           `json:"collaborative"`
+	Items         []PlaylistItem `json:"items"`
+	Snapshot      int            `json:"snapshot"`
+}
+
+type PlaylistItem struct {
+	TrackID spotify.ID `json:"track_id"`
+	AddedAt time.Time  `json:"added_at"`
+}
+
+type Play struct {
+	TrackID  spotify.ID `json:"track_id"`
+	PlayedAt time.Time  `json:"played_at"`
+}
+
+type Saved struct {
+	TrackID spotify.ID `json:"track_id"`
+	AddedAt time.Time  `json:"added_at"`
+}
+
+type Player struct {
+	Devices    []spotify.PlayerDevice `json:"devices"`
+	TrackID    spotify.ID             `json:"track_id"`
+	ContextURI spotify.URI            `json:"context_uri"`
+	Playing    bool                   `json:"playing"`
+	ProgressMs int                    `json:"progress_ms"`
+	Shuffle    bool                   `json:"shuffle"`
+	Repeat     string                 `json:"repeat"`
+}
+
+// ActiveDevice returns the active device, if any.
+func (p *Player) ActiveDevice() *spotify.PlayerDevice {
+	for i := range p.Devices {
+		if p.Devices[i].Active {
+			return &p.Devices[i]
+		}
+	}
+	return nil
+}
+
+// NewState returns an empty state for the given user.
+func NewState(userID string) *State {
+	return &State{
+		User: spotify.PrivateUser{
+			User: spotify.User{
+				ID:          userID,
+				DisplayName: userID,
+				URI:         spotify.URI("spotify:user:" + userID),
+			},
+			Country: "AU",
+			Product: "premium",
+		},
+		Tracks:         map[spotify.ID]spotify.FullTrack{},
+		Artists:        map[spotify.ID]spotify.FullArtist{},
+		Albums:         map[spotify.ID]spotify.FullAlbum{},
+		RelatedArtists: map[spotify.ID][]spotify.ID{},
+		Player:         Player{Repeat: "off"},
+	}
+}
+
+// AddArtist adds an artist to the catalog.
+func (s *State) AddArtist(id spotify.ID, name string, genres ...string) spotify.FullArtist {
+	artist := spotify.FullArtist{
+		SimpleArtist: spotify.SimpleArtist{
+			ID:   id,
+			Name: name,
+			URI:  spotify.URI("spotify:artist:" + id),
+		},
+		Genres:     genres,
+		Popularity: 50,
+	}
+	s.Artists[id] = artist
+	return artist
+}
+
+// AddAlbum adds an album by an existing artist to the catalog.
+func (s *State) AddAlbum(id spotify.ID, name string, artistID spotify.ID, releaseDate string) spotify.FullAlbum {
+	album := spotify.FullAlbum{
+		SimpleAlbum: spotify.SimpleAlbum{
+			ID:                   id,
+			Name:                 name,
+			AlbumType:            "album",
+			AlbumGroup:           "album",
+			Artists:              []spotify.SimpleArtist{s.Artists[artistID].SimpleArtist},
+			URI:                  spotify.URI("spotify:album:" + id),
+			ReleaseDate:          releaseDate,
+			ReleaseDatePrecision: "day",
+		},
+		Copyrights: []spotify.Copyright{
+			{Text: "(C) " + releaseDate[:4] + " " + s.Artists[artistID].Name, Type: "C"},
+			{Text: "(P) " + releaseDate[:4] + " Fake Records", Type: "P"},
+		},
+		Popularity: 50,
+	}
+	s.Albums[id] = album
+	return album
+}
+
+// AddTrack adds a track on an existing album to the catalog.
+func (s *State) AddTrack(id spotify.ID, name string, albumID spotify.ID, trackNumber int, durationMs int) spotify.FullTrack {
+	album := s.Albums[albumID]
+	album.TotalTracks++
+	s.Albums[albumID] = album
+
+	track := spotify.FullTrack{
+		SimpleTrack: spotify.SimpleTrack{
+			ID:          id,
+			Name:        name,
+			Artists:     album.Artists,
+			DiscNumber:  1,
+			TrackNumber: spotify.Numeric(trackNumber),
+			Duration:    spotify.Numeric(durationMs),
+			URI:         spotify.URI("spotify:track:" + id),
+			Type:        "track",
+		},
+		Album:       album.SimpleAlbum,
+		ExternalIDs: map[string]string{"isrc": "FAKE" + string(id)},
+		Popularity:  spotify.Numeric(40 + trackNumber*5),
+	}
+	s.Tracks[id] = track
+	return track
+}
+
+// AddPlaylist adds a playlist owned by ownerID.
+func (s *State) AddPlaylist(id spotify.ID, name string, ownerID string, trackIDs ...spotify.ID) *Playlist {
+	playlist := &Playlist{
+		ID:      id,
+		Name:    name,
+		OwnerID: ownerID,
+		Public:  true,
+	}
+	for _, trackID := range trackIDs {
+		playlist.Items = append(playlist.Items, PlaylistItem{TrackID: trackID, AddedAt: time.Now()})
+	}
+	s.Playlists = append(s.Playlists, playlist)
+	return playlist
+}
+
+// Playlist finds a playlist by ID.
+func (s *State) Playlist(id spotify.ID) *Playlist {
+	for _, playlist := range s.Playlists {
+		if playlist.ID == id {
+			return playlist
+		}
+	}
+	return nil
+}
+
+// Play makes a track the current one on the active device and records the
+// previous track in the recently played history.
+func (s *State) Play(trackID spotify.ID, contextURI spotify.URI) {
+	if s.Player.TrackID != "" {
+		s.RecentlyPlayed = append([]Play{{TrackID: s.Player.TrackID, PlayedAt: time.Now()}}, s.RecentlyPlayed...)
+	}
+	s.Player.TrackID = trackID
+	s.Player.ContextURI = contextURI
+	s.Player.Playing = true
+	s.Player.ProgressMs = 0
+}
+
+// NewSeededState returns a state with a small catalog, a couple of devices,
+// playlists, listening history and something playing.
+func NewSeededState() *State {
+	s := NewState("fake-user")
+	s.User.DisplayName = "Fake User"
+	s.User.Followers.Count = 42
+
+	genres := [][]string{
+		{"indie rock", "alternative"},
+		{"synthpop", "electronic"},
+		{"jazz", "soul"},
+		{"hip hop"},
+	}
+
+	var artistIDs []spotify.ID
+	var trackIDs []spotify.ID
+	for a := 1; a <= len(genres); a++ {
+		artistID := spotify.ID(fmt.Sprintf("artist%02d", a))
+		s.AddArtist(artistID, fmt.Sprintf("Fake Artist %d", a), genres[a-1]...)
+		artistIDs = append(artistIDs, artistID)
+
+		for b := 1; b <= 2; b++ {
+			albumID := spotify.ID(fmt.Sprintf("album%02d%02d", a, b))
+			s.AddAlbum(albumID, fmt.Sprintf("Fake Album %d.%d", a, b), artistID, fmt.Sprintf("20%02d-0%d-15", 10+a, b))
+
+			for t := 1; t <= 5; t++ {
+				trackID := spotify.ID(fmt.Sprintf("track%02d%02d%02d", a, b, t))
+				s.AddTrack(trackID, fmt.Sprintf("Fake Song %d.%d.%d", a, b, t), albumID, t, 180000+t*7000)
+				trackIDs = append(trackIDs, trackID)
+			}
+		}
+	}
+
+	for i, artistID := range artistIDs {
+		for j, related := range artistIDs {
+			if i != j {
+				s.RelatedArtists[artistID] = append(s.RelatedArtists[artistID], related)
+			}
+		}
+	}
+
+	s.AddPlaylist("playlist01", "Fake Favourites", s.User.ID, trackIDs[0], trackIDs[5], trackIDs[10])
+	s.AddPlaylist("playlist02", "Fake Road Trip", s.User.ID, trackIDs[20:30]...)
+	s.AddPlaylist("playlist03", "Someone Else's Mix", "someone-else", trackIDs[30:35]...)
+
+	s.Player.Devices = []spotify.PlayerDevice{
+		{ID: "device01", Name: "Fake Laptop", Type: "Computer", Active: true, Volume: 60},
+		{ID: "device02", Name: "Fake Phone", Type: "Smartphone", Volume: 80},
+	}
+
+	now := time.Now()
+	for i := 0; i < 20; i++ {
+		s.RecentlyPlayed = append(s.RecentlyPlayed, Play{
+			TrackID:  trackIDs[(i*3)%len(trackIDs)],
+			PlayedAt: now.Add(-time.Duration(i+1) * 4 * time.Minute),
+		})
+	}
+
+	for i := 0; i < len(trackIDs); i += 4 {
+		s.SavedTracks = append(s.SavedTracks, Saved{TrackID: trackIDs[i], AddedAt: now.Add(-time.Duration(i) * time.Hour)})
+		s.TopTracks = append(s.TopTracks, trackIDs[i])
+	}
+	s.TopArtists = artistIDs
+
+	s.Player.TrackID = trackIDs[0]
+	s.Player.ContextURI = "spotify:playlist:playlist01"
+	s.Player.Playing = true
+
+	return s
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/dialog"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// albumLabel reads the record label from the phonographic copyright, e.g.
+// "(P) 2020 Some Records", as the zmb3 album doesn't carry the label itself.
+func albumLabel(copyrights []spotify.Copyright) string {
+	for _, copyright := range copyrights {
+		if copyright.Type != "P" {
+			continue
+		}
+		label := strings.TrimSpace(copyright.Text)
+		for _, prefix := range []string{"℗", "(P)", "(p)"} {
+			label = strings.TrimSpace(strings.TrimPrefix(label, prefix))
+		}
+		if year, rest, ok := strings.Cut(label, " "); ok && len(year) == 4 && strings.Trim(year, "0123456789") == "" {
+			label = strings.TrimSpace(rest)
+		}
+		return label
+	}
+	return ""
+}
+
+// albumDiscs splits tracks, which Spotify lists in disc order, by disc.
+func albumDiscs(tracks []spotify.SimpleTrack) []dialog.AlbumDisc {
+	var discs []dialog.AlbumDisc
+	for _, track := range tracks {
+		if len(discs) == 0 || discs[len(discs)-1].Number != int(track.DiscNumber) {
+			discs = append(discs, dialog.AlbumDisc{Number: int(track.DiscNumber)})
+		}
+		disc := &discs[len(discs)-1]
+		disc.Tracks = append(disc.Tracks, dialog.AlbumTrack{
+			ID:          track.ID.String(),
+			TrackNumber: int(track.TrackNumber),
+			Name:        track.Name,
+			Duration:    formatDuration(int(track.Duration)),
+		})
+	}
+	return discs
+}
+
+// GetAlbumInfo opens the album dialog for album_id.
+func (h *RpcHandlers) GetAlbumInfo(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+	albumID := spotify.ID(r.FormValue("album_id"))
+	if albumID == "" {
+		log.Printf("No album ID provided")
+		return
+	}
+
+	album, err := spotifyClient.GetAlbum(r.Context(), albumID)
+	if err != nil || album == nil {
+		log.Printf("Failed to get album details: %v", err)
+		showSlowDown(w, err)
+		return
+	}
+
+	// The album only embeds the first page of tracks
+	tracks := album.Tracks.Tracks
+	if len(tracks) < int(album.Tracks.Total) {
+		tracks, err = spotifyservice.Collect(spotifyservice.AlbumTracks(r.Context(), spotifyClient, albumID))
+		if err != nil {
+			log.Printf("Failed to get tracks for album %s: %v", albumID, err)
+			tracks = album.Tracks.Tracks
+		}
+	}
+
+	props := dialog.AlbumProps{
+		AlbumID:     albumID.String(),
+		Name:        album.Name,
+		AlbumType:   album.AlbumType,
+		ReleaseDate: album.ReleaseDate,
+		Label:       albumLabel(album.Copyrights),
+		Copyrights: utils.MapSlice(album.Copyrights, func(copyright spotify.Copyright) string {
+			return copyright.Text
+		}),
+		Discs: albumDiscs(tracks),
+	}
+	if len(album.Artists) > 0 {
+		props.ArtistName = album.Artists[0].Name
+		props.ArtistID = album.Artists[0].ID.String()
+	}
+	if len(album.Images) > 0 {
+		props.Image = album.Images[0].URL
+	}
+
+	signals.DialogOpen = true
+	signals.DialogType = "album"
+	signals.DialogItemID = albumID.String()
+	w.UpdateSignals(signals)
+	w.ReplaceInner("#dialog-content", dialog.AlbumInfo(props))
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"sync"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/dialog"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// maxArtistAlbums caps how much of a discography the artist dialog lists.
+const maxArtistAlbums = 50
+
+// discographyGroups are the sections of the artist dialog, in display order.
+var discographyGroups = []struct {
+	group string
+	label string
+}{
+	{"album", "Albums"},
+	{"single", "Singles & EPs"},
+	{"compilation", "Compilations"},
+	{"appears_on", "Appears on"},
+}
+
+// groupDiscography splits albums into the discography sections, dropping
+// empty ones. Albums without a group fall back to their album type.
+func groupDiscography(albums []spotify.SimpleAlbum) []dialog.AlbumGroup {
+	byGroup := map[string][]spotify.SimpleAlbum{}
+	for _, album := range albums {
+		group := album.AlbumGroup
+		if group == "" {
+			group = album.AlbumType
+		}
+		byGroup[group] = append(byGroup[group], album)
+	}
+
+	var groups []dialog.AlbumGroup
+	for _, section := range discographyGroups {
+		if len(byGroup[section.group]) > 0 {
+			groups = append(groups, dialog.AlbumGroup{Label: section.label, Albums: byGroup[section.group]})
+		}
+	}
+	return groups
+}
+
+// GetArtistInfo opens the artist dialog for artist_id. Top tracks, albums and
+// related artists are optional; a section that fails to load is left out.
+func (h *RpcHandlers) GetArtistInfo(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+	artistID := spotify.ID(r.FormValue("artist_id"))
+	if artistID == "" {
+		log.Printf("No artist ID provided")
+		return
+	}
+
+	var (
+		wg        sync.WaitGroup
+		topTracks []spotify.FullTrack
+		albums    []spotify.SimpleAlbum
+		related   []spotify.FullArtist
+	)
+	wg.Go(func() {
+		var err error
+		topTracks, err = spotifyClient.GetArtistsTopTracks(r.Context(), artistID, "from_token")
+		if err != nil {
+			log.Printf("Failed to get top tracks for artist %s: %v", artistID, err)
+		}
+	})
+	wg.Go(func() {
+		var err error
+		albums, err = spotifyservice.Collect(spotifyservice.ArtistAlbums(r.Context(), spotifyClient, artistID, nil, spotifyservice.WithLimit(maxArtistAlbums)))
+		if err != nil {
+			log.Printf("Failed to get albums for artist %s: %v", artistID, err)
+		}
+	})
+	wg.Go(func() {
+		var err error
+		related, err = spotifyClient.GetRelatedArtists(r.Context(), artistID)
+		if err != nil {
+			log.Printf("Failed to get related artists for artist %s: %v", artistID, err)
+		}
+	})
+
+	artist, err := spotifyClient.GetArtist(r.Context(), artistID)
+	wg.Wait()
+	if err != nil || artist == nil {
+		log.Printf("Failed to get artist details: %v", err)
+		showSlowDown(w, err)
+		return
+	}
+
+	var image string
+	if len(artist.Images) > 0 {
+		image = artist.Images[0].URL
+	}
+
+	signals.DialogOpen = true
+	signals.DialogType = "artist"
+	signals.DialogItemID = artistID.String()
+	w.UpdateSignals(signals)
+	w.ReplaceInner("#dialog-content", dialog.ArtistInfo(dialog.ArtistProps{
+		ArtistID:  artistID.String(),
+		Name:      artist.Name,
+		Image:     image,
+		Genres:    artist.Genres,
+		Followers: int(artist.Followers.Count),
+		TopTracks: utils.MapSlice(topTracks, func(item spotify.FullTrack) spotify.SimpleTrack {
+			track := item.SimpleTrack
+			track.Album = item.Album
+			return track
+		}),
+		Discography: groupDiscography(albums),
+		Related:     related,
+	}))
+	showSavedState(w, r.Context(), spotifyClient, utils.MapSlice(topTracks, func(item spotify.FullTrack) spotify.ID {
+		return item.ID
+	}))
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	historyservice "github.com/thattomperson/spotifgo/internal/services/history"
+	"github.com/thattomperson/spotifgo/internal/ui/components/history"
+	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// HistorySignal holds the date filters of the listening history, as
+// YYYY-MM-DD dates in the browser's time zone, and that time zone.
+type HistorySignal struct {
+	HistoryFrom string `json:"history_from"`
+	HistoryTo   string `json:"history_to"`
+	HistoryTZ   string `json:"history_tz"`
+}
+
+// historyPageSize is how many plays each scroll loads.
+const historyPageSize = 20
+
+// historyQuery turns the date filters into a query for a page of plays. Both
+// dates are inclusive, and unknown time zones fall back to UTC.
+func historyQuery(signal HistorySignal, offset int) historyservice.Query {
+	location, err := time.LoadLocation(signal.HistoryTZ)
+	if err != nil {
+		location = time.UTC
+	}
+
+	query := historyservice.Query{Offset: offset, Limit: historyPageSize}
+	if from, err := time.ParseInLocation(time.DateOnly, signal.HistoryFrom, location); err == nil {
+		query.From = from
+	}
+	if to, err := time.ParseInLocation(time.DateOnly, signal.HistoryTo, location); err == nil {
+		query.To = to.AddDate(0, 0, 1)
+	}
+	return query
+}
+
+func showHistoryError(w *star.DatastarWriter[SpotigoSignals], err error) {
+	log.Printf("Failed to read listening history: %v", err)
+	w.ShowToast("Listening history unavailable", "Something went wrong, please try again later.", star.WithVariant(toast.VariantError))
+}
+
+// historyUserID looks up the Spotify user the history belongs to. It renders
+// the disabled notice when the server keeps no history.
+func (h *RpcHandlers) historyUserID(w *star.DatastarWriter[SpotigoSignals], r *http.Request) (string, bool) {
+	if h.history == nil {
+		w.ReplaceInner("#history", history.Disabled())
+		return "", false
+	}
+	return h.currentUserID(w, r)
+}
+
+// historyPlays reads a page of the user's plays matching the date filters.
+func (h *RpcHandlers) historyPlays(ctx context.Context, userID string, signal HistorySignal, offset int) (history.PlaysProps, error) {
+	query := historyQuery(signal, offset)
+	total, err := h.history.CountPlays(ctx, userID, query)
+	if err != nil {
+		return history.PlaysProps{}, err
+	}
+	plays, err := h.history.Plays(ctx, userID, query)
+	if err != nil {
+		return history.PlaysProps{}, err
+	}
+
+	next := offset + len(plays)
+	return history.PlaysProps{
+		Tracks: utils.MapSlice(plays, func(play historyservice.Play) spotify.SimpleTrack {
+			return play.Track
+		}),
+		PlayedAt: utils.MapSlice(plays, func(play historyservice.Play) time.Time {
+			return play.PlayedAt
+		}),
+		Total:   total,
+		Next:    next,
+		HasMore: len(plays) > 0 && next < total,
+	}, nil
+}
+
+// renderHistory fills #history with the user's plays, or asks them to opt in.
+func (h *RpcHandlers) renderHistory(w *star.DatastarWriter[SpotigoSignals], r *http.Request, userID string, signal HistorySignal) {
+	consented, err := h.history.Consented(r.Context(), userID)
+	if err != nil {
+		showHistoryError(w, err)
+		return
+	}
+	if !consented {
+		w.ReplaceInner("#history", history.Consent())
+		return
+	}
+
+	props, err := h.historyPlays(r.Context(), userID, signal, 0)
+	if err != nil {
+		showHistoryError(w, err)
+		return
+	}
+	w.ReplaceInner("#history", history.View(props))
+	showSavedState(w, r.Context(), h.services.ForRequest(r), utils.MapSlice(props.Tracks, func(track spotify.SimpleTrack) spotify.ID {
+		return track.ID
+	}))
+}
+
+// GetHistory renders the listening history section.
+func (h *RpcHandlers) GetHistory(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	userID, ok := h.historyUserID(w, r)
+	if !ok {
+		return
+	}
+	h.renderHistory(w, r, userID, signals.HistorySignal)
+}
+
+// GetHistoryPlays renders the plays matching the date filters from offset,
+// replacing the list when filters change and appending as the user scrolls.
+func (h *RpcHandlers) GetHistoryPlays(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	userID, ok := h.historyUserID(w, r)
+	if !ok {
+		return
+	}
+
+	offset := pageOffset(r)
+	props, err := h.historyPlays(r.Context(), userID, signals.HistorySignal, offset)
+	if err != nil {
+		showHistoryError(w, err)
+		return
+	}
+
+	if offset == 0 {
+		w.ReplaceInner("#history-plays-view", history.Plays(props))
+	} else {
+		w.Append("#history-plays", history.Cards(props))
+		w.Replace("#history-more", history.MorePlays(props.Next, props.HasMore))
+	}
+	showSavedState(w, r.Context(), h.services.ForRequest(r), utils.MapSlice(props.Tracks, func(track spotify.SimpleTrack) spotify.ID {
+		return track.ID
+	}))
+}
+
+// EnableHistory opts the user in to having their history collected, and
+// collects what Spotify has right away.
+func (h *RpcHandlers) EnableHistory(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	userID, ok := h.historyUserID(w, r)
+	if !ok {
+		return
+	}
+	token := h.token(r)
+	if token == nil {
+		log.Printf("No token to collect history with")
+		return
+	}
+
+	if err := h.history.Consent(r.Context(), userID, token); err != nil {
+		showHistoryError(w, err)
+		return
+	}
+	if _, err := h.historyCollector.Collect(r.Context(), historyservice.User{ID: userID, Token: token}); err != nil {
+		// The collector tries again on its next poll
+		log.Printf("Failed to collect history for %s: %v", userID, err)
+	}
+
+	h.renderHistory(w, r, userID, signals.HistorySignal)
+	w.ShowToast("Keeping your listening history", "New plays are saved every so often, even while you're away.")
+}
+
+// DisableHistory opts the user out and deletes everything kept for them.
+func (h *RpcHandlers) DisableHistory(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	userID, ok := h.historyUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.history.Forget(r.Context(), userID); err != nil {
+		showHistoryError(w, err)
+		return
+	}
+	w.ReplaceInner("#history", history.Consent())
+	w.ShowToast("Listening history deleted", "spotifgo no longer keeps your plays.")
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
+	trackcard "github.com/thattomperson/spotifgo/internal/ui/components/track-card"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// showSavedState patches the liked signal of every track in ids, so the
+// hearts on their cards match Liked Songs. Failures only leave hearts empty.
+func showSavedState[T any](w *star.DatastarWriter[T], ctx context.Context, spotifyClient spotifyservice.Service, ids []spotify.ID) {
+	if len(ids) == 0 {
+		return
+	}
+
+	saved, err := spotifyservice.SavedTrackState(ctx, spotifyClient, ids)
+	if err != nil {
+		log.Printf("Failed to check saved tracks: %v", err)
+		return
+	}
+
+	signals := make(map[string]bool, len(saved))
+	for id, isSaved := range saved {
+		signals[trackcard.LikedSignal(id)] = isSaved
+	}
+	w.Generator.MarshalAndPatchSignals(signals)
+}
+
+// showLibraryError asks the user to log in again when their session predates
+// the library scopes, and falls back to handleSpotifyError otherwise.
+func showLibraryError[T any](w *star.DatastarWriter[T], err error) {
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusForbidden {
+		w.ShowToast("Log in again to use Liked Songs", "Spotify needs your permission to change your library.",
+			star.WithVariant(toast.VariantWarning),
+			star.WithLink("Log in", utils.Path(w.Generator.Context(), "/auth/login")),
+		)
+		return
+	}
+	handleSpotifyError(w, err)
+}
+
+// ToggleSavedTrack adds track_id to Liked Songs, or removes it if it's
+// already there.
+func (h *RpcHandlers) ToggleSavedTrack(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+	trackID := spotify.ID(r.FormValue("track_id"))
+	if trackID == "" {
+		log.Printf("No track ID provided")
+		return
+	}
+
+	contains, err := spotifyClient.UserHasTracks(r.Context(), trackID)
+	if err != nil {
+		showLibraryError(w, err)
+		log.Printf("Failed to check saved track %s: %v", trackID, err)
+		return
+	}
+
+	saved := len(contains) > 0 && contains[0]
+	if saved {
+		err = spotifyClient.RemoveTracksFromLibrary(r.Context(), trackID)
+	} else {
+		err = spotifyClient.AddTracksToLibrary(r.Context(), trackID)
+	}
+	if err != nil {
+		showLibraryError(w, err)
+		log.Printf("Failed to update saved track %s: %v", trackID, err)
+		return
+	}
+
+	w.Generator.MarshalAndPatchSignals(map[string]bool{
+		trackcard.LikedSignal(trackID): !saved,
+	})
+	if saved {
+		w.ShowToast("Removed from Liked Songs", "The song is no longer in your library.")
+	} else {
+		w.ShowToast("Added to Liked Songs", "You'll find the song in your library.")
+	}
+}
@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/player"
+	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
+	trackcard "github.com/thattomperson/spotifgo/internal/ui/components/track-card"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// PlayerSignal holds the positions of the seek and volume sliders, and the
+// device queue and play commands are sent to.
+type PlayerSignal struct {
+	PlayerProgress int    `json:"player_progress"`
+	PlayerVolume   int    `json:"player_volume"`
+	PlayerDevice   string `json:"player_device,omitempty"`
+}
+
+// targetDevice picks the device for a queue or play command: the device_id
+// parameter, else the device from the signals. Nil means the active device.
+func targetDevice(r *http.Request, signal PlayerSignal) *spotify.ID {
+	deviceID := r.FormValue("device_id")
+	if deviceID == "" {
+		deviceID = signal.PlayerDevice
+	}
+	if deviceID == "" {
+		return nil
+	}
+	id := spotify.ID(deviceID)
+	return &id
+}
+
+// renderPlayer patches the Now Playing card and its controls from state, and
+// returns the slider positions to patch into the signals.
+func renderPlayer[T any](w *star.DatastarWriter[T], state *spotify.PlayerState) PlayerSignal {
+	if state.Item == nil {
+		w.ReplaceInner("#playing-song", trackcard.TrackCardEmpty())
+	} else {
+		track := state.Item.SimpleTrack
+		track.Album = state.Item.Album
+		w.ReplaceInner("#playing-song", trackcard.TrackCard(trackcard.Props{
+			Track: track,
+		}))
+	}
+
+	props := player.ControlsProps{
+		Active:     state.Device.ID != "",
+		DeviceName: state.Device.Name,
+		Playing:    state.Playing,
+		Shuffle:    state.ShuffleState,
+		Repeat:     state.RepeatState,
+		ProgressMs: int(state.Progress),
+		Volume:     int(state.Device.Volume),
+	}
+	if state.Item != nil {
+		props.DurationMs = int(state.Item.Duration)
+	}
+	w.ReplaceInner("#player-controls", player.Controls(props))
+
+	return PlayerSignal{
+		PlayerProgress: props.ProgressMs,
+		PlayerVolume:   props.Volume,
+		// Keep targeting the last device while nothing is active
+		PlayerDevice: state.Device.ID.String(),
+	}
+}
+
+// QueuedSongsSignal remembers the tracks queued through spotifgo in this page
+// session, so the queue panel can highlight them.
+type QueuedSongsSignal struct {
+	QueuedSongs []spotify.ID `json:"queued_songs"`
+}
+
+// maxQueuedSongs caps how many queued tracks are remembered for highlighting.
+const maxQueuedSongs = 200
+
+func queueTrackIDs(queue *spotify.Queue) []spotify.ID {
+	return utils.MapSlice(queue.Items, func(item spotify.FullTrack) spotify.ID {
+		return item.ID
+	})
+}
+
+// renderQueue patches the queue panel, highlighting the queued tracks.
+func renderQueue[T any](w *star.DatastarWriter[T], queue *spotify.Queue, queued []spotify.ID) {
+	if len(queue.Items) == 0 {
+		w.ReplaceInner("#queue", trackcard.ListEmpty("Nothing queued"))
+		return
+	}
+
+	w.ReplaceInner("#queue", trackcard.List(trackcard.ListProps{
+		Tracks: utils.MapSlice(queue.Items, func(item spotify.FullTrack) spotify.SimpleTrack {
+			track := item.SimpleTrack
+			track.Album = item.Album
+			return track
+		}),
+		Highlight: queued,
+	}))
+}
+
+// showPlayerError explains playback failures Spotify reports for the user's
+// account or devices, and falls back to handleSpotifyError for anything else.
+func showPlayerError[T any](w *star.DatastarWriter[T], err error) {
+	var spotifyErr spotify.Error
+	if !errors.As(err, &spotifyErr) {
+		handleSpotifyError(w, err)
+		return
+	}
+
+	switch spotifyErr.Status {
+	case http.StatusNotFound:
+		w.ReplaceInner("#player-controls", player.Controls(player.ControlsProps{}))
+		w.ShowToast("No active device", "Start playing on a Spotify device and try again.", star.WithVariant(toast.VariantWarning))
+	case http.StatusForbidden:
+		w.ShowToast("Premium required", "Controlling playback needs Spotify Premium.", star.WithVariant(toast.VariantWarning))
+	case http.StatusUnauthorized:
+		handleSpotifyError(w, err)
+	default:
+		if !showSlowDown(w, err) {
+			w.ShowToast("Playback failed", spotifyErr.Message, star.WithVariant(toast.VariantError))
+		}
+	}
+}
+
+// controlPlayer runs a playback command, then refreshes the Now Playing card
+// so the controls show the new state. It reports whether the command worked.
+func (h *RpcHandlers) controlPlayer(w *star.DatastarWriter[SpotigoSignals], r *http.Request, action string, command func(ctx context.Context, spotifyClient spotifyservice.Service) error) bool {
+	spotifyClient := h.services.ForRequest(r)
+
+	if err := command(r.Context(), spotifyClient); err != nil {
+		showPlayerError(w, err)
+		log.Printf("Failed to %s: %v", action, err)
+		return false
+	}
+
+	state, err := spotifyClient.PlayerState(r.Context())
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get player state: %v", err)
+		return true
+	}
+	w.Generator.MarshalAndPatchSignals(renderPlayer(w, state))
+	if state.Item != nil {
+		showSavedState(w, r.Context(), spotifyClient, []spotify.ID{state.Item.ID})
+	}
+	return true
+}
+
+// PlayerPlay resumes playback, on the target device when one is chosen. With
+// a context_uri parameter it starts that album or playlist instead.
+func (h *RpcHandlers) PlayerPlay(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	opts := &spotify.PlayOptions{DeviceID: targetDevice(r, signals.PlayerSignal)}
+	if contextURI := spotify.URI(r.FormValue("context_uri")); contextURI != "" {
+		opts.PlaybackContext = &contextURI
+	}
+	h.controlPlayer(w, r, "start playback", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.PlayOpt(ctx, opts)
+	})
+}
+
+func (h *RpcHandlers) PlayerPause(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	h.controlPlayer(w, r, "pause playback", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Pause(ctx)
+	})
+}
+
+func (h *RpcHandlers) PlayerNext(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	h.controlPlayer(w, r, "skip to next track", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Next(ctx)
+	})
+}
+
+func (h *RpcHandlers) PlayerPrevious(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	h.controlPlayer(w, r, "skip to previous track", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Previous(ctx)
+	})
+}
+
+// PlayerSeek jumps to $player_progress in the current track.
+func (h *RpcHandlers) PlayerSeek(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	position := max(signals.PlayerProgress, 0)
+	h.controlPlayer(w, r, "seek", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Seek(ctx, position)
+	})
+}
+
+// PlayerShuffle turns shuffle on or off, from the state parameter.
+func (h *RpcHandlers) PlayerShuffle(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	shuffle, err := strconv.ParseBool(r.FormValue("state"))
+	if err != nil {
+		log.Printf("Invalid shuffle state %q", r.FormValue("state"))
+		return
+	}
+	h.controlPlayer(w, r, "set shuffle", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Shuffle(ctx, shuffle)
+	})
+}
+
+// PlayerRepeat sets the repeat mode to the state parameter: off, context or
+// track.
+func (h *RpcHandlers) PlayerRepeat(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	state := r.FormValue("state")
+	if state != "off" && state != "context" && state != "track" {
+		log.Printf("Invalid repeat state %q", state)
+		return
+	}
+	h.controlPlayer(w, r, "set repeat", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Repeat(ctx, state)
+	})
+}
+
+// PlayerVolume sets the active device's volume to $player_volume.
+func (h *RpcHandlers) PlayerVolume(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	volume := min(max(signals.PlayerVolume, 0), 100)
+	h.controlPlayer(w, r, "set volume", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.Volume(ctx, volume)
+	})
+}
+
+func deviceOptions(devices []spotify.PlayerDevice) []player.Device {
+	return utils.MapSlice(devices, func(device spotify.PlayerDevice) player.Device {
+		return player.Device{
+			ID:         device.ID.String(),
+			Name:       device.Name,
+			Type:       device.Type,
+			Volume:     int(device.Volume),
+			Active:     device.Active,
+			Restricted: device.Restricted,
+		}
+	})
+}
+
+// GetDevices fills the devices popover with the user's Spotify Connect
+// devices.
+func (h *RpcHandlers) GetDevices(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	devices, err := h.services.ForRequest(r).PlayerDevices(r.Context())
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get devices: %v", err)
+		return
+	}
+
+	w.ReplaceInner("#devices", player.Devices(player.DevicesProps{
+		Devices: deviceOptions(devices),
+	}))
+}
+
+// PlayerTransfer moves playback to the device_id parameter and starts
+// playing there.
+func (h *RpcHandlers) PlayerTransfer(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+	deviceID := spotify.ID(r.FormValue("device_id"))
+	if deviceID == "" {
+		log.Printf("No device ID provided")
+		return
+	}
+
+	devices, err := spotifyClient.PlayerDevices(r.Context())
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get devices: %v", err)
+		return
+	}
+	index := slices.IndexFunc(devices, func(device spotify.PlayerDevice) bool {
+		return device.ID == deviceID
+	})
+	if index == -1 {
+		w.ShowToast("Device not found", "The device may have gone offline.", star.WithVariant(toast.VariantWarning))
+		w.ReplaceInner("#devices", player.Devices(player.DevicesProps{
+			Devices: deviceOptions(devices),
+		}))
+		return
+	}
+
+	if !h.controlPlayer(w, r, "transfer playback", func(ctx context.Context, spotifyClient spotifyservice.Service) error {
+		return spotifyClient.TransferPlayback(ctx, deviceID, true)
+	}) {
+		return
+	}
+
+	for i := range devices {
+		devices[i].Active = i == index
+	}
+	w.ReplaceInner("#devices", player.Devices(player.DevicesProps{
+		Devices: deviceOptions(devices),
+	}))
+	w.ShowToast("Playing on "+devices[index].Name, "Playback has moved to this device.")
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/playlists"
+	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
+	trackcard "github.com/thattomperson/spotifgo/internal/ui/components/track-card"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// PlaylistBrowserSignal holds the playlist open in the Playlists column, or
+// nothing while it lists the user's playlists.
+type PlaylistBrowserSignal struct {
+	BrowsePlaylist string `json:"browse_playlist"`
+}
+
+type playlistBrowserSignals struct {
+	CurrentTab string `json:"current_tab"`
+	PlaylistBrowserSignal
+}
+
+// playlistPageSize is how many playlists or tracks each scroll loads.
+const playlistPageSize = 20
+
+// pageOffset reads the offset parameter of a paged RPC.
+func pageOffset(r *http.Request) int {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	return max(offset, 0)
+}
+
+// GetPlaylists renders a page of the user's playlists, starting at offset.
+func (h *RpcHandlers) GetPlaylists(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	offset := pageOffset(r)
+	page, err := h.services.ForRequest(r).CurrentUsersPlaylists(r.Context(), spotifyservice.PageOptions{Limit: playlistPageSize, Offset: offset})
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get playlists: %v", err)
+		return
+	}
+
+	var found []spotify.SimplePlaylist
+	for _, playlist := range page.Playlists {
+		// Spotify lists playlists that are no longer available without an ID
+		if playlist.ID != "" {
+			found = append(found, playlist)
+		}
+	}
+	next := offset + len(page.Playlists)
+	hasMore := len(page.Playlists) > 0 && next < int(page.Total)
+
+	if offset == 0 {
+		w.ReplaceInner("#playlists", playlists.List(playlists.ListProps{
+			Playlists: found,
+			Next:      next,
+			HasMore:   hasMore,
+		}))
+		return
+	}
+	w.Append("#playlist-list", playlists.Cards(found))
+	w.Replace("#playlists-more", playlists.MorePlaylists(next, hasMore))
+}
+
+// playlistTracks picks the tracks out of playlist items, skipping episodes
+// and local files.
+func playlistTracks(items []spotify.PlaylistItem) []spotify.SimpleTrack {
+	var tracks []spotify.SimpleTrack
+	for _, item := range items {
+		if item.IsLocal || item.Track.Track == nil {
+			continue
+		}
+		track := item.Track.Track.SimpleTrack
+		track.Album = item.Track.Track.Album
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// showPlaylistError tells the user a playlist is gone, and falls back to
+// handleSpotifyError for anything else.
+func showPlaylistError[T any](w *star.DatastarWriter[T], err error) {
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusNotFound {
+		w.ShowToast("Playlist not found", "It may have been deleted or made private.", star.WithVariant(toast.VariantWarning))
+		return
+	}
+	handleSpotifyError(w, err)
+}
+
+// GetPlaylistTracks opens playlist_id in the Playlists column, or appends the
+// page of its tracks starting at offset.
+func (h *RpcHandlers) GetPlaylistTracks(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+	playlistID := spotify.ID(r.FormValue("playlist_id"))
+	if playlistID == "" {
+		log.Printf("No playlist ID provided")
+		return
+	}
+	offset := pageOffset(r)
+
+	page, err := spotifyClient.GetPlaylistItems(r.Context(), playlistID, spotify.Limit(playlistPageSize), spotify.Offset(offset))
+	if err != nil {
+		showPlaylistError(w, err)
+		log.Printf("Failed to get tracks for playlist %s: %v", playlistID, err)
+		return
+	}
+	tracks := playlistTracks(page.Items)
+	next := offset + len(page.Items)
+	hasMore := len(page.Items) > 0 && next < int(page.Total)
+
+	if offset == 0 {
+		playlist, err := spotifyClient.GetPlaylist(r.Context(), playlistID)
+		if err != nil {
+			showPlaylistError(w, err)
+			log.Printf("Failed to get playlist %s: %v", playlistID, err)
+			return
+		}
+		simple := playlist.SimplePlaylist
+		// The full playlist's tracks shadow the simple playlist's count
+		simple.Tracks.Total = playlist.Tracks.Total
+
+		w.Generator.MarshalAndPatchSignals(playlistBrowserSignals{
+			CurrentTab: "playlists",
+			PlaylistBrowserSignal: PlaylistBrowserSignal{
+				BrowsePlaylist: playlistID.String(),
+			},
+		})
+		w.ReplaceInner("#playlist-view", playlists.View(playlists.ViewProps{
+			Playlist: simple,
+			Tracks:   tracks,
+			Next:     next,
+			HasMore:  hasMore,
+		}))
+	} else {
+		w.Append("#playlist-tracks", trackcard.Cards(trackcard.ListProps{Tracks: tracks}))
+		w.Replace("#playlist-tracks-more", playlists.MoreTracks(playlistID.String(), next, hasMore))
+	}
+
+	showSavedState(w, r.Context(), spotifyClient, utils.MapSlice(tracks, func(track spotify.SimpleTrack) spotify.ID {
+		return track.ID
+	}))
+}
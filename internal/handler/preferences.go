@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// currentUserID looks up the Spotify ID of the user making the request, which
+// their preferences and history are kept under.
+func (h *RpcHandlers) currentUserID(w *star.DatastarWriter[SpotigoSignals], r *http.Request) (string, bool) {
+	user, err := h.services.ForRequest(r).CurrentUser(r.Context())
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get current user: %v", err)
+		return "", false
+	}
+	return user.ID, true
+}
+
+// Preferences only save the user a click, so failing to read or write them is
+// logged rather than shown, and nothing is remembered without a store.
+
+func (h *RpcHandlers) defaultPlaylist(ctx context.Context, userID string) spotify.ID {
+	if h.preferences == nil {
+		return ""
+	}
+	playlistID, err := h.preferences.DefaultPlaylist(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to read default playlist of %s: %v", userID, err)
+	}
+	return playlistID
+}
+
+func (h *RpcHandlers) setDefaultPlaylist(ctx context.Context, userID string, playlistID spotify.ID) {
+	if h.preferences == nil {
+		return
+	}
+	if err := h.preferences.SetDefaultPlaylist(ctx, userID, playlistID); err != nil {
+		log.Printf("Failed to save default playlist of %s: %v", userID, err)
+	}
+}
+
+func (h *RpcHandlers) recentPlaylists(ctx context.Context, userID string) []spotify.ID {
+	if h.preferences == nil {
+		return nil
+	}
+	playlists, err := h.preferences.RecentPlaylists(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to read recent playlists of %s: %v", userID, err)
+	}
+	return playlists
+}
+
+func (h *RpcHandlers) usePlaylist(ctx context.Context, userID string, playlistID spotify.ID) {
+	if h.preferences == nil {
+		return
+	}
+	if err := h.preferences.UsePlaylist(ctx, userID, playlistID); err != nil {
+		log.Printf("Failed to save recent playlist of %s: %v", userID, err)
+	}
+}
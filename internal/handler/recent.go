@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	trackcard "github.com/thattomperson/spotifgo/internal/ui/components/track-card"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// RecentPlaysSignal tracks the window of listening history shown under
+// Recently Played: its newest and oldest plays in Unix milliseconds, and
+// whether older plays can be loaded.
+type RecentPlaysSignal struct {
+	RecentNewest int64 `json:"recent_newest"`
+	RecentOldest int64 `json:"recent_oldest"`
+	RecentMore   bool  `json:"recent_more"`
+}
+
+// recentSongsPageSize is how many plays the first load and each "load more"
+// fetch.
+const recentSongsPageSize = 20
+
+// recentSongsList renders plays as cards bound to $recent_songs.
+func recentSongsList(items []spotify.RecentlyPlayedItem) trackcard.ListProps {
+	return trackcard.ListProps{
+		ID: "recent-songs",
+		Tracks: utils.MapSlice(items, func(item spotify.RecentlyPlayedItem) spotify.SimpleTrack {
+			return item.Track
+		}),
+		PlayedAt: utils.MapSlice(items, func(item spotify.RecentlyPlayedItem) time.Time {
+			return item.PlayedAt
+		}),
+	}
+}
+
+func recentTrackIDs(items []spotify.RecentlyPlayedItem) []spotify.ID {
+	return utils.MapSlice(items, func(item spotify.RecentlyPlayedItem) spotify.ID {
+		return item.Track.ID
+	})
+}
+
+// refreshRecentSongs renders the first page of Recently Played, or once it's
+// shown prepends only the plays newer than $recent_newest. It updates the
+// recent signals in place and returns the tracks it added.
+func refreshRecentSongs(w *star.DatastarWriter[SpotigoSignals], ctx context.Context, spotifyClient spotifyservice.Service, signals *SpotigoSignals) ([]spotify.ID, error) {
+	if signals.RecentNewest == 0 {
+		items, err := spotifyClient.PlayerRecentlyPlayedOpt(ctx, &spotify.RecentlyPlayedOptions{
+			Limit: recentSongsPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		w.Replace("#recent-songs", trackcard.List(recentSongsList(items)))
+		ids := recentTrackIDs(items)
+		signals.RecentSongs = &ids
+		signals.RecentPlaysSignal = RecentPlaysSignal{}
+		if len(items) > 0 {
+			signals.RecentPlaysSignal = RecentPlaysSignal{
+				RecentNewest: items[0].PlayedAt.UnixMilli(),
+				RecentOldest: items[len(items)-1].PlayedAt.UnixMilli(),
+				RecentMore:   len(items) == recentSongsPageSize,
+			}
+		}
+		return ids, nil
+	}
+
+	items, err := spotifyClient.PlayerRecentlyPlayedOpt(ctx, &spotify.RecentlyPlayedOptions{
+		Limit:        recentSongsPageSize,
+		AfterEpochMs: signals.RecentNewest,
+	})
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	w.Prepend("#recent-songs", trackcard.Cards(recentSongsList(items)))
+	ids := recentTrackIDs(items)
+	var shown []spotify.ID
+	if signals.RecentSongs != nil {
+		shown = *signals.RecentSongs
+	}
+	recent := slices.Concat(ids, shown)
+	signals.RecentSongs = &recent
+	signals.RecentNewest = items[0].PlayedAt.UnixMilli()
+	return ids, nil
+}
+
+type recentSongsSignals struct {
+	RecentSongsSignal
+	RecentPlaysSignal
+}
+
+// GetRecentSongs appends the page of plays before $recent_oldest to Recently
+// Played.
+func (h *RpcHandlers) GetRecentSongs(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	if signals.RecentOldest == 0 {
+		log.Printf("No recently played cursor provided")
+		return
+	}
+	spotifyClient := h.services.ForRequest(r)
+
+	items, err := spotifyClient.PlayerRecentlyPlayedOpt(r.Context(), &spotify.RecentlyPlayedOptions{
+		Limit:         recentSongsPageSize,
+		BeforeEpochMs: signals.RecentOldest,
+	})
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get recently played songs: %v", err)
+		return
+	}
+
+	plays := signals.RecentPlaysSignal
+	plays.RecentMore = len(items) == recentSongsPageSize
+	var shown []spotify.ID
+	if signals.RecentSongs != nil {
+		shown = *signals.RecentSongs
+	}
+	ids := recentTrackIDs(items)
+	recent := slices.Concat(shown, ids)
+	if len(items) > 0 {
+		w.Append("#recent-songs", trackcard.Cards(recentSongsList(items)))
+		plays.RecentOldest = items[len(items)-1].PlayedAt.UnixMilli()
+	}
+
+	w.Generator.MarshalAndPatchSignals(recentSongsSignals{
+		RecentSongsSignal: RecentSongsSignal{RecentSongs: &recent},
+		RecentPlaysSignal: plays,
+	})
+	showSavedState(w, r.Context(), spotifyClient, ids)
+}
@@ -1,22 +1,32 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/thattomperson/spotifgo/internal/auth"
+	"github.com/thattomperson/spotifgo/internal/ratelimit"
+	historyservice "github.com/thattomperson/spotifgo/internal/services/history"
+	"github.com/thattomperson/spotifgo/internal/services/preferences"
+	"github.com/thattomperson/spotifgo/internal/services/recommend"
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
 	"github.com/thattomperson/spotifgo/internal/ui/components/dialog"
 	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
 	trackcard "github.com/thattomperson/spotifgo/internal/ui/components/track-card"
 	"github.com/thattomperson/spotifgo/internal/utils"
 	"github.com/thattomperson/spotifgo/internal/utils/star"
+	"github.com/thattomperson/spotifgo/internal/utils/star/rpc"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
 )
 
 type RecommendedSongsSignal struct {
@@ -31,78 +41,181 @@ type SpotigoSignals struct {
 	CurrentTab string `json:"current_tab"`
 	RecommendedSongsSignal
 	RecentSongsSignal
+	RecentPlaysSignal
 	SelectedSong string `json:"selected_song"`
 	DialogType   string `json:"dialog_type"`
 	DialogItemID string `json:"dialog_item_id"`
 	DialogOpen   bool   `json:"dialog_open"`
+	PlaylistPickerSignal
+	NewPlaylistSignal
+	PlayerSignal
+	QueuedSongsSignal
+	SearchSignal
+	PlaylistBrowserSignal
+	TopRangeSignal
+	HistorySignal
+}
+
+// PlaylistPickerSignal carries the tracks waiting for the user to pick a
+// playlist, and the state of the picker's inputs.
+type PlaylistPickerSignal struct {
+	PlaylistTracks   []spotify.ID `json:"playlist_tracks"`
+	PlaylistSearch   string       `json:"playlist_search"`
+	PlaylistRemember bool         `json:"playlist_remember"`
 }
 
 type RpcHandlers struct {
-	authService *auth.Auth
+	services         spotifyservice.Provider
+	recommender      recommend.Recommender
+	preferences      *preferences.Store
+	token            func(r *http.Request) *oauth2.Token
+	history          *historyservice.Store
+	historyCollector *historyservice.Collector
+	dedupeByISRC     bool
+	topTracks        int
+	topArtists       int
 }
 
-func NewRpcHandlers(authService *auth.Auth) *RpcHandlers {
-	return &RpcHandlers{
-		authService: authService,
+type RpcHandlersOption func(*RpcHandlers)
+
+func WithRecommender(recommender recommend.Recommender) RpcHandlersOption {
+	return func(h *RpcHandlers) {
+		h.recommender = recommender
 	}
 }
 
+// WithPreferences sets where per-user choices, like the default playlist, are
+// remembered. Without it nothing is.
+func WithPreferences(store *preferences.Store) RpcHandlersOption {
+	return func(h *RpcHandlers) {
+		h.preferences = store
+	}
+}
+
+// WithToken sets how to get the Spotify token of the user making a request,
+// e.g. auth.Auth.Token.
+func WithToken(token func(r *http.Request) *oauth2.Token) RpcHandlersOption {
+	return func(h *RpcHandlers) {
+		h.token = token
+	}
+}
+
+// WithHistory keeps listening history in store for users who opt in, and
+// collects it with collector. Without it the history section says it's off.
+func WithHistory(store *historyservice.Store, collector *historyservice.Collector) RpcHandlersOption {
+	return func(h *RpcHandlers) {
+		h.history = store
+		h.historyCollector = collector
+	}
+}
+
+// WithDedupeByISRC makes adding to a playlist also skip tracks whose ISRC
+// matches a track already in it, e.g. the single and album release of a song.
+func WithDedupeByISRC(enabled bool) RpcHandlersOption {
+	return func(h *RpcHandlers) {
+		h.dedupeByISRC = enabled
+	}
+}
+
+// WithTopLimits sets how many top tracks and top artists the "You" column
+// shows.
+func WithTopLimits(tracks int, artists int) RpcHandlersOption {
+	return func(h *RpcHandlers) {
+		h.topTracks = tracks
+		h.topArtists = artists
+	}
+}
+
+func NewRpcHandlers(services spotifyservice.Provider, opts ...RpcHandlersOption) *RpcHandlers {
+	h := &RpcHandlers{
+		services:    services,
+		recommender: recommend.Default(),
+		token: func(r *http.Request) *oauth2.Token {
+			return nil
+		},
+		topTracks:  50,
+		topArtists: 20,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// playingSongSignals are the signals GetPlayingSong owns. The poll patches
+// only these, so it doesn't clobber inputs the user is typing into, such as
+// the search box or the new playlist form.
+type playingSongSignals struct {
+	SelectedSong string `json:"selected_song"`
+	PlayerSignal
+	RecentSongsSignal
+	RecentPlaysSignal
+}
+
 func (h *RpcHandlers) GetPlayingSong(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
-	spotifyClient := h.authService.GetSpotifyClient(r)
+	spotifyClient := h.services.ForRequest(r)
 	wg := sync.WaitGroup{}
+	// Each request collects the tracks it shows, to check them in one go
+	var playingIDs, recentIDs, queueIDs []spotify.ID
 
 	wg.Go(func() {
-		song, err := spotifyClient.PlayerCurrentlyPlaying(r.Context())
+		state, err := spotifyClient.PlayerState(r.Context())
 		if err != nil {
 			spew.Dump(err)
-			w.Generator.Redirect("/auth/login")
-			log.Printf("Failed to get currently playing song: %v", err)
-			return
-		}
-
-		if song.Item == nil {
-			w.ReplaceInner("#playing-song", trackcard.TrackCardEmpty())
+			handleSpotifyError(w, err)
+			log.Printf("Failed to get player state: %v", err)
 			return
 		}
 
-		if signals.SelectedSong == "" {
-			signals.SelectedSong = song.Item.ID.String()
+		if state.Item != nil {
+			playingIDs = []spotify.ID{state.Item.ID}
+			if signals.SelectedSong == "" {
+				signals.SelectedSong = state.Item.ID.String()
+			}
 		}
-
-		track := song.Item.SimpleTrack
-		track.Album = song.Item.Album
-
-		w.ReplaceInner("#playing-song", trackcard.TrackCard(trackcard.Props{
-			Track: track,
-		}))
+		signals.PlayerSignal = renderPlayer(w, state)
 	})
 	wg.Go(func() {
-		songs, err := spotifyClient.PlayerRecentlyPlayed(r.Context())
+		var err error
+		recentIDs, err = refreshRecentSongs(w, r.Context(), spotifyClient, signals)
 		if err != nil {
-			w.Generator.Redirect("/auth/login")
+			handleSpotifyError(w, err)
 			log.Printf("Failed to get recently played songs: %v", err)
+		}
+	})
+	wg.Go(func() {
+		queue, err := spotifyClient.GetQueue(r.Context())
+		if err != nil {
+			// Only the queue panel is missing, so don't send the user to
+			// log in over it
+			log.Printf("Failed to get queue: %v", err)
+			w.ReplaceInner("#queue", trackcard.ListEmpty("Queue unavailable"))
 			return
 		}
 
-		w.Replace("#recent-songs", trackcard.List(trackcard.ListProps{
-			ID: "recent-songs",
-			Tracks: utils.MapSlice(songs, func(item spotify.RecentlyPlayedItem) spotify.SimpleTrack {
-				return item.Track
-			}),
-		}))
+		queueIDs = queueTrackIDs(queue)
+		renderQueue(w, queue, signals.QueuedSongs)
 	})
 
 	wg.Wait()
-	w.UpdateSignals(signals)
+	showSavedState(w, r.Context(), spotifyClient, slices.Concat(playingIDs, recentIDs, queueIDs))
+	w.Generator.MarshalAndPatchSignals(playingSongSignals{
+		SelectedSong:      signals.SelectedSong,
+		PlayerSignal:      signals.PlayerSignal,
+		RecentSongsSignal: signals.RecentSongsSignal,
+		RecentPlaysSignal: signals.RecentPlaysSignal,
+	})
 }
 
 type QueueTrackSignal struct {
 	RecentSongsSignal
 	RecommendedSongsSignal
+	PlayerSignal
+	QueuedSongsSignal
 }
 
 func (h *RpcHandlers) QueueTrack(w *star.DatastarWriter[QueueTrackSignal], signals *QueueTrackSignal, r *http.Request) {
-	spotifyClient := h.authService.GetSpotifyClient(r)
+	spotifyClient := h.services.ForRequest(r)
 
 	spew.Dump(signals)
 
@@ -110,6 +223,10 @@ func (h *RpcHandlers) QueueTrack(w *star.DatastarWriter[QueueTrackSignal], signa
 	var trackIDs []spotify.ID
 	if singleID := r.FormValue("track_id"); singleID != "" {
 		trackIDs = []spotify.ID{spotify.ID(singleID)}
+	} else if ids := r.Form["track_ids[]"]; len(ids) > 0 {
+		trackIDs = utils.MapSlice(ids, func(id string) spotify.ID {
+			return spotify.ID(id)
+		})
 	} else if signals.RecommendedSongs != nil {
 		trackIDs = *signals.RecommendedSongs
 	} else if signals.RecentSongs != nil {
@@ -124,31 +241,46 @@ func (h *RpcHandlers) QueueTrack(w *star.DatastarWriter[QueueTrackSignal], signa
 		return
 	}
 
-	var successCount, failCount int
-	var successNames []string
+	tracks, missing := spotifyservice.LookupTracks(r.Context(), spotifyClient, trackIDs)
 
-	for _, trackID := range trackIDs {
-		track, err := spotifyClient.GetTrack(r.Context(), spotify.ID(trackID))
-		if err != nil {
-			log.Printf("Failed to get track %s: %v", trackID, err)
-			failCount++
-			continue
+	successCount, failCount := 0, len(missing)
+	var successNames []string
+	var successIDs []spotify.ID
+
+	deviceID := targetDevice(r, signals.PlayerSignal)
+	for _, track := range tracks {
+		err := spotifyClient.QueueSongOpt(r.Context(), track.ID, &spotify.PlayOptions{DeviceID: deviceID})
+		var spotifyErr spotify.Error
+		if errors.As(err, &spotifyErr) && (spotifyErr.Status == http.StatusNotFound || spotifyErr.Status == http.StatusForbidden) {
+			// Every other track would fail the same way
+			showPlayerError(w, err)
+			log.Printf("Failed to queue songs: %v", err)
+			return
 		}
-
-		err = spotifyClient.QueueSong(r.Context(), track.ID)
 		if err != nil {
 			log.Printf("Failed to queue song %s: %v", track.Name, err)
 			failCount++
 			continue
 		}
 
-		w.Append("#toasts", toast.Toast(toast.Props{
-			Title:       "Queued " + track.Name,
-			Description: "You can now enjoy this song in your queue.",
-		}))
-
 		successCount++
 		successNames = append(successNames, track.Name)
+		successIDs = append(successIDs, track.ID)
+	}
+
+	if successCount > 0 {
+		queued := append(signals.QueuedSongs, successIDs...)
+		queued = queued[max(len(queued)-maxQueuedSongs, 0):]
+		w.Generator.MarshalAndPatchSignals(QueuedSongsSignal{
+			QueuedSongs: queued,
+		})
+
+		if queue, err := spotifyClient.GetQueue(r.Context()); err != nil {
+			log.Printf("Failed to get queue: %v", err)
+		} else {
+			renderQueue(w, queue, queued)
+			showSavedState(w, r.Context(), spotifyClient, queueTrackIDs(queue))
+		}
 	}
 
 	// Show appropriate success/failure message
@@ -165,122 +297,348 @@ func (h *RpcHandlers) QueueTrack(w *star.DatastarWriter[QueueTrackSignal], signa
 	}
 }
 
-func (h *RpcHandlers) AddToPlaylist(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
-	spotifyClient := h.authService.GetSpotifyClient(r)
-
-	// Get track IDs from both single track_id and multiple track_ids[]
-	var trackIDs []string
+// requestedTrackIDs reads the tracks an action applies to from a single
+// track_id, multiple track_ids[] or the list signals.
+func requestedTrackIDs(r *http.Request, signals *SpotigoSignals) []spotify.ID {
 	if singleID := r.FormValue("track_id"); singleID != "" {
-		trackIDs = []string{singleID}
-	} else {
-		trackIDs = r.Form["track_ids[]"]
+		return []spotify.ID{spotify.ID(singleID)}
+	} else if ids := r.Form["track_ids[]"]; len(ids) > 0 {
+		return utils.MapSlice(ids, func(id string) spotify.ID {
+			return spotify.ID(id)
+		})
+	} else if len(signals.PlaylistTracks) > 0 {
+		return signals.PlaylistTracks
+	} else if signals.RecommendedSongs != nil {
+		return *signals.RecommendedSongs
+	} else if signals.RecentSongs != nil {
+		return *signals.RecentSongs
 	}
+	return nil
+}
 
-	if len(trackIDs) == 0 {
-		w.ShowToast("No tracks specified", "Please select tracks to add to playlist.")
-		return
+// editablePlaylists lists the playlists the current user can add tracks to.
+func editablePlaylists(ctx context.Context, spotifyClient spotifyservice.Service) ([]spotify.SimplePlaylist, error) {
+	user, err := spotifyClient.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var playlists []spotify.SimplePlaylist
+	for playlist, err := range spotifyservice.Playlists(ctx, spotifyClient) {
+		if err != nil {
+			return nil, err
+		}
+		if playlist.Owner.ID == user.ID || playlist.Collaborative {
+			playlists = append(playlists, playlist)
+		}
 	}
+	return playlists, nil
+}
 
-	// Get current playback context to find the playlist being played
-	currentlyPlaying, err := spotifyClient.PlayerCurrentlyPlaying(r.Context())
+type playlistPickerSignals struct {
+	DialogOpen bool   `json:"dialog_open"`
+	DialogType string `json:"dialog_type"`
+	PlaylistPickerSignal
+}
+
+// showPlaylistPicker opens the playlist picker for trackIDs, with the default
+// playlist first and recently used playlists after it.
+func (h *RpcHandlers) showPlaylistPicker(w *star.DatastarWriter[SpotigoSignals], r *http.Request, trackIDs []spotify.ID) {
+	playlists, err := editablePlaylists(r.Context(), h.services.ForRequest(r))
 	if err != nil {
-		w.Generator.Redirect("/auth/login")
-		log.Printf("Failed to get currently playing: %v", err)
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get playlists: %v", err)
 		return
 	}
 
-	var targetPlaylistID spotify.ID
-	var targetPlaylistName string
+	userID, ok := h.currentUserID(w, r)
+	if !ok {
+		return
+	}
+	defaultPlaylist := h.defaultPlaylist(r.Context(), userID)
+	recentPlaylists := h.recentPlaylists(r.Context(), userID)
 
-	// Check if the user is currently playing from a playlist
-	if currentlyPlaying == nil || currentlyPlaying.PlaybackContext.Type != "playlist" {
-		// Fallback to first user playlist if not playing from a playlist
-		playlists, err := spotifyClient.CurrentUsersPlaylists(r.Context())
-		if err != nil {
-			w.Generator.Redirect("/auth/login")
-			log.Printf("Failed to get playlists: %v", err)
-			return
+	rank := func(playlist spotify.SimplePlaylist) int {
+		if playlist.ID == defaultPlaylist {
+			return -1
 		}
-
-		if len(playlists.Playlists) == 0 {
-			w.ShowToast("No playlists found", "Please create a playlist first to add songs to it.")
-			return
+		if i := slices.Index(recentPlaylists, playlist.ID); i != -1 {
+			return i
 		}
+		return len(recentPlaylists)
+	}
+	slices.SortStableFunc(playlists, func(a, b spotify.SimplePlaylist) int {
+		return rank(a) - rank(b)
+	})
 
-		// Use the first playlist as fallback
-		playlist := playlists.Playlists[0]
-		targetPlaylistID = playlist.ID
-		targetPlaylistName = playlist.Name
-	} else {
-		// Extract playlist ID from the URI (format: spotify:playlist:PLAYLIST_ID)
-		playlistURI := string(currentlyPlaying.PlaybackContext.URI)
-		// Split by ":" to get ["spotify", "playlist", "PLAYLIST_ID"]
-		parts := strings.Split(playlistURI, ":")
-		if len(parts) != 3 || parts[0] != "spotify" || parts[1] != "playlist" {
-			w.ShowToast("Invalid playlist context", "Unable to determine current playlist.")
-			return
+	options := utils.MapSlice(playlists, func(playlist spotify.SimplePlaylist) dialog.PlaylistOption {
+		option := dialog.PlaylistOption{
+			ID:      playlist.ID.String(),
+			Name:    playlist.Name,
+			Tracks:  int(playlist.Tracks.Total),
+			Default: playlist.ID == defaultPlaylist,
+			Recent:  slices.Contains(recentPlaylists, playlist.ID),
 		}
+		if len(playlist.Images) > 0 {
+			option.Image = playlist.Images[0].URL
+		}
+		return option
+	})
+
+	w.Generator.MarshalAndPatchSignals(playlistPickerSignals{
+		DialogOpen: true,
+		DialogType: "playlist-picker",
+		PlaylistPickerSignal: PlaylistPickerSignal{
+			PlaylistTracks: trackIDs,
+		},
+	})
+	w.ReplaceInner("#dialog-content", dialog.PlaylistPicker(dialog.PlaylistPickerProps{
+		TrackCount: len(trackIDs),
+		Playlists:  options,
+	}))
+}
+
+// ChoosePlaylist opens the playlist picker for the requested tracks.
+func (h *RpcHandlers) ChoosePlaylist(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	trackIDs := requestedTrackIDs(r, signals)
+	if len(trackIDs) == 0 {
+		w.ShowToast("No tracks specified", "Please select tracks to add to playlist.")
+		return
+	}
+
+	h.showPlaylistPicker(w, r, trackIDs)
+}
 
-		targetPlaylistID = spotify.ID(parts[2])
+// AddToPlaylist adds the requested tracks to the playlist_id parameter, or to
+// the user's default playlist. Without either it asks the user to pick one.
+func (h *RpcHandlers) AddToPlaylist(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
 
-		// Get playlist details for the toast message
-		playlist, err := spotifyClient.GetPlaylist(r.Context(), targetPlaylistID)
-		if err != nil {
-			w.Generator.Redirect("/auth/login")
-			log.Printf("Failed to get playlist details: %v", err)
+	trackIDs := requestedTrackIDs(r, signals)
+	if len(trackIDs) == 0 {
+		w.ShowToast("No tracks specified", "Please select tracks to add to playlist.")
+		return
+	}
+	userID, ok := h.currentUserID(w, r)
+	if !ok {
+		return
+	}
+
+	targetPlaylistID := spotify.ID(r.FormValue("playlist_id"))
+	chosen := targetPlaylistID != ""
+	if !chosen {
+		targetPlaylistID = h.defaultPlaylist(r.Context(), userID)
+	}
+	if targetPlaylistID == "" {
+		h.showPlaylistPicker(w, r, trackIDs)
+		return
+	}
+
+	playlist, err := spotifyClient.GetPlaylist(r.Context(), targetPlaylistID)
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusNotFound {
+		log.Printf("Playlist %s not found: %v", targetPlaylistID, err)
+		if !chosen {
+			// The default playlist has been deleted, forget it and ask again
+			h.setDefaultPlaylist(r.Context(), userID, "")
+			h.showPlaylistPicker(w, r, trackIDs)
 			return
 		}
-		targetPlaylistName = playlist.Name
+		w.ShowToast("Playlist not found", "The playlist may have been deleted.", star.WithVariant(toast.VariantError))
+		return
 	}
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get playlist details: %v", err)
+		return
+	}
+	targetPlaylistName := playlist.Name
 
-	// Process each track
-	var successCount, failCount int
-	var successNames []string
-	var spotifyTrackIDs []spotify.ID
+	// Validate the tracks in batches, then add them in chunks
+	tracks, missing := spotifyservice.LookupTracks(r.Context(), spotifyClient, trackIDs)
+	trackNames := make(map[spotify.ID]string, len(tracks))
+	for _, track := range tracks {
+		trackNames[track.ID] = track.Name
+	}
 
-	// First, validate and collect all track IDs
-	for _, trackID := range trackIDs {
-		track, err := spotifyClient.GetTrack(r.Context(), spotify.ID(trackID))
+	// Skip tracks already in the playlist, unless the user asked to add them anyway
+	var duplicates []spotify.ID
+	if r.FormValue("allow_duplicates") != "true" {
+		existing, err := spotifyservice.LoadPlaylistTracks(r.Context(), spotifyClient, targetPlaylistID)
 		if err != nil {
-			log.Printf("Failed to get track %s: %v", trackID, err)
-			failCount++
-			continue
+			handleSpotifyError(w, err)
+			log.Printf("Failed to get playlist items: %v", err)
+			return
 		}
-		spotifyTrackIDs = append(spotifyTrackIDs, track.ID)
-		successNames = append(successNames, track.Name)
+		tracks = slices.DeleteFunc(tracks, func(track *spotify.FullTrack) bool {
+			if existing.Contains(track, h.dedupeByISRC) {
+				duplicates = append(duplicates, track.ID)
+				return true
+			}
+			existing.Add(track)
+			return false
+		})
 	}
 
-	// Add tracks to playlist in batch if we have valid tracks
-	if len(spotifyTrackIDs) > 0 {
-		_, err = spotifyClient.AddTracksToPlaylist(r.Context(), targetPlaylistID, spotifyTrackIDs...)
-		if err != nil {
-			log.Printf("Failed to add tracks to playlist: %v", err)
-			// If batch add fails, count all as failures
-			failCount += len(spotifyTrackIDs)
-			successCount = 0
-		} else {
-			successCount = len(spotifyTrackIDs)
+	result := spotifyservice.AddTracksToPlaylistChunked(r.Context(), spotifyClient, targetPlaylistID, utils.MapSlice(tracks, func(track *spotify.FullTrack) spotify.ID {
+		return track.ID
+	}))
+	for _, err := range result.Errors {
+		log.Printf("Failed to add tracks to playlist %s: %v", targetPlaylistID, err)
+	}
+
+	successCount := len(result.Added)
+	failCount := len(missing) + len(result.Failed)
+	successNames := utils.MapSlice(result.Added, func(id spotify.ID) string {
+		return trackNames[id]
+	})
+
+	if successCount > 0 {
+		h.usePlaylist(r.Context(), userID, targetPlaylistID)
+		if chosen && signals.PlaylistRemember {
+			h.setDefaultPlaylist(r.Context(), userID, targetPlaylistID)
 		}
 	}
+	if chosen {
+		w.Generator.MarshalAndPatchSignals(playlistPickerSignals{
+			DialogOpen: false,
+			DialogType: "playlist-picker",
+			PlaylistPickerSignal: PlaylistPickerSignal{
+				PlaylistTracks: []spotify.ID{},
+			},
+		})
+	}
 
 	// Show appropriate success/failure message
-	if successCount > 0 && failCount == 0 {
+	if len(duplicates) > 0 {
+		addAnyway := url.Values{
+			"playlist_id":      {targetPlaylistID.String()},
+			"allow_duplicates": {"true"},
+		}
+		for _, id := range duplicates {
+			addAnyway.Add("track_ids[]", id.String())
+		}
+		addAnywayAction := star.WithAction("Add anyway", rpc.Post(r.Context(), "add-to-playlist", rpc.WithParameters(addAnyway)))
+
+		if successCount == 0 && failCount == 0 {
+			description := fmt.Sprintf("%d songs are already in this playlist.", len(duplicates))
+			if len(duplicates) == 1 {
+				description = trackNames[duplicates[0]] + " is already in this playlist."
+			}
+			w.ShowToast("Already in "+targetPlaylistName, description, addAnywayAction)
+		} else {
+			description := "Added to " + targetPlaylistName + "."
+			if failCount > 0 {
+				description = playlistFailureDescription(len(missing), len(result.Failed))
+			}
+			w.ShowToast(fmt.Sprintf("%d added, %d already present", successCount, len(duplicates)), description, addAnywayAction)
+		}
+	} else if successCount > 0 && failCount == 0 {
 		if successCount == 1 {
 			w.ShowToast("Added "+successNames[0]+" to "+targetPlaylistName, "The song has been added to your playlist.")
 		} else {
 			w.ShowToast(fmt.Sprintf("Added %d songs to %s", successCount, targetPlaylistName), "All songs have been added to your playlist.")
 		}
 	} else if successCount > 0 && failCount > 0 {
-		w.ShowToast(fmt.Sprintf("Added %d/%d songs to %s", successCount, successCount+failCount, targetPlaylistName), fmt.Sprintf("%d songs failed to add", failCount))
+		w.ShowToast(fmt.Sprintf("Added %d/%d songs to %s", successCount, successCount+failCount, targetPlaylistName), playlistFailureDescription(len(missing), len(result.Failed)))
 	} else {
-		w.ShowToast("Failed to add songs to playlist", "All tracks failed to be added to playlist.")
+		w.ShowToast("Failed to add songs to playlist", playlistFailureDescription(len(missing), len(result.Failed)), star.WithVariant(toast.VariantError))
 	}
 }
 
+// NewPlaylistSignal holds the create playlist form. Source names the list
+// signal whose tracks go into the playlist.
+type NewPlaylistSignal struct {
+	NewPlaylistName        string `json:"new_playlist_name"`
+	NewPlaylistDescription string `json:"new_playlist_description"`
+	NewPlaylistPublic      bool   `json:"new_playlist_public"`
+	NewPlaylistSource      string `json:"new_playlist_source"`
+}
+
+type createPlaylistSignals struct {
+	DialogOpen bool `json:"dialog_open"`
+	NewPlaylistSignal
+}
+
+// CreatePlaylist saves the recent or recommended songs as a new playlist.
+func (h *RpcHandlers) CreatePlaylist(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+
+	name := strings.TrimSpace(signals.NewPlaylistName)
+	if name == "" {
+		w.ShowToast("Name required", "Please give the playlist a name.", star.WithVariant(toast.VariantWarning))
+		return
+	}
+
+	var trackIDs []spotify.ID
+	switch signals.NewPlaylistSource {
+	case "recommended_songs":
+		if signals.RecommendedSongs != nil {
+			trackIDs = *signals.RecommendedSongs
+		}
+	case "recent_songs":
+		if signals.RecentSongs != nil {
+			trackIDs = *signals.RecentSongs
+		}
+	}
+	if len(trackIDs) == 0 {
+		w.ShowToast("No tracks specified", "Please select tracks to save as a playlist.")
+		return
+	}
+
+	user, err := spotifyClient.CurrentUser(r.Context())
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get current user: %v", err)
+		return
+	}
+
+	playlist, err := spotifyClient.CreatePlaylistForUser(r.Context(), user.ID, name, strings.TrimSpace(signals.NewPlaylistDescription), signals.NewPlaylistPublic, false)
+	if err != nil {
+		if !showSlowDown(w, err) {
+			w.ShowToast("Failed to create playlist", err.Error(), star.WithVariant(toast.VariantError))
+		}
+		log.Printf("Failed to create playlist: %v", err)
+		return
+	}
+	h.usePlaylist(r.Context(), user.ID, playlist.ID)
+
+	w.Generator.MarshalAndPatchSignals(createPlaylistSignals{
+		DialogOpen: false,
+	})
+
+	// Validate the tracks in batches, then add them in chunks
+	tracks, missing := spotifyservice.LookupTracks(r.Context(), spotifyClient, trackIDs)
+	result := spotifyservice.AddTracksToPlaylistChunked(r.Context(), spotifyClient, playlist.ID, utils.MapSlice(tracks, func(track *spotify.FullTrack) spotify.ID {
+		return track.ID
+	}))
+	for _, err := range result.Errors {
+		log.Printf("Failed to add tracks to playlist %s: %v", playlist.ID, err)
+	}
+
+	var opts []star.ToastOption
+	if link := playlist.ExternalURLs["spotify"]; link != "" {
+		opts = append(opts, star.WithLink("Open in Spotify", link))
+	}
+
+	description := fmt.Sprintf("Added %d songs.", len(result.Added))
+	if failCount := len(missing) + len(result.Failed); failCount > 0 {
+		description = fmt.Sprintf("Added %d songs, %s", len(result.Added), playlistFailureDescription(len(missing), len(result.Failed)))
+	}
+	w.ShowToast("Created "+playlist.Name, description, opts...)
+}
+
+const recommendationsLimit = 20
+
 func (h *RpcHandlers) UpdateSelectedSong(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
-	spotifyClient := h.authService.GetSpotifyClient(r)
+	spotifyClient := h.services.ForRequest(r)
 
-	song, _ := spotifyClient.GetTrack(r.Context(), spotify.ID(signals.SelectedSong))
+	song, err := spotifyClient.GetTrack(r.Context(), spotify.ID(signals.SelectedSong))
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get selected song: %v", err)
+		return
+	}
 	track := song.SimpleTrack
 	track.Album = song.Album
 
@@ -288,41 +646,24 @@ func (h *RpcHandlers) UpdateSelectedSong(w *star.DatastarWriter[SpotigoSignals],
 		Track: track,
 	}))
 
-	tracks, err := spotifyClient.GetRecommendations(r.Context(), spotify.Seeds{
-		Tracks: []spotify.ID{spotify.ID(signals.SelectedSong)},
-	}, nil)
+	tracks, err := h.recommender.Recommend(r.Context(), spotifyClient, song.ID, recommendationsLimit)
 	if err != nil {
-		w.Generator.Redirect("/auth/login")
+		handleSpotifyError(w, err)
 		log.Printf("Failed to get recommendations: %v", err)
 		return
 	}
 
 	w.Replace("#recommended-songs", trackcard.List(trackcard.ListProps{
 		ID:     "recommended-songs",
-		Tracks: tracks.Tracks,
-	}))
-}
-
-func (h *RpcHandlers) GetTopSongs(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
-	spotifyClient := h.authService.GetSpotifyClient(r)
-
-	songs, err := spotifyClient.CurrentUsersTopTracks(r.Context())
-	if err != nil {
-		log.Printf("Failed to get top songs: %v", err)
-		return
-	}
-
-	w.ReplaceInner("#top-songs", trackcard.List(trackcard.ListProps{
-		Tracks: utils.MapSlice(songs.Tracks, func(item spotify.FullTrack) spotify.SimpleTrack {
-			track := item.SimpleTrack
-			track.Album = item.Album
-			return track
-		}),
+		Tracks: tracks,
 	}))
+	showSavedState(w, r.Context(), spotifyClient, append(utils.MapSlice(tracks, func(track spotify.SimpleTrack) spotify.ID {
+		return track.ID
+	}), song.ID))
 }
 
 func (h *RpcHandlers) GetDetailedTrackInfo(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
-	spotifyClient := h.authService.GetSpotifyClient(r)
+	spotifyClient := h.services.ForRequest(r)
 	trackID := r.FormValue("track_id")
 
 	if trackID == "" {
@@ -338,6 +679,7 @@ func (h *RpcHandlers) GetDetailedTrackInfo(w *star.DatastarWriter[SpotigoSignals
 	track, err := spotifyClient.GetTrack(r.Context(), spotify.ID(trackID))
 	if err != nil || track == nil {
 		log.Printf("Failed to get track details: %v", err)
+		showSlowDown(w, err)
 		return
 	}
 
@@ -397,6 +739,40 @@ func (h *RpcHandlers) GetDetailedTrackInfo(w *star.DatastarWriter[SpotigoSignals
 	w.ReplaceInner("#dialog-content", dialog.DetailedTrackInfo(dialogProps))
 }
 
+// showSlowDown shows a "slow down" toast if err was caused by rate limiting.
+func showSlowDown[T any](w *star.DatastarWriter[T], err error) bool {
+	if !ratelimit.IsRateLimited(err) {
+		return false
+	}
+	w.ShowToast("Slow down", "Spotify is busy, please try again in a moment.", star.WithVariant(toast.VariantWarning))
+	return true
+}
+
+// handleSpotifyError shows a "slow down" toast when the request was rate
+// limited, and sends the user to log in again for any other failure.
+func handleSpotifyError[T any](w *star.DatastarWriter[T], err error) {
+	if showSlowDown(w, err) {
+		return
+	}
+	w.Generator.Redirect(utils.Path(w.Generator.Context(), "/auth/login"))
+}
+
+// playlistFailureDescription separates tracks that couldn't be found from
+// tracks Spotify refused to add.
+func playlistFailureDescription(notFound int, failed int) string {
+	var parts []string
+	if notFound > 0 {
+		parts = append(parts, fmt.Sprintf("%d not found", notFound))
+	}
+	if failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed to add", failed))
+	}
+	if len(parts) == 0 {
+		return "No tracks were added to the playlist."
+	}
+	return strings.Join(parts, ", ") + "."
+}
+
 func formatDuration(duration int) string {
 	d := time.Duration(duration) * time.Millisecond
 	minutes := int(d.Minutes())
@@ -0,0 +1,156 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/thattomperson/spotifgo/internal/handler"
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// newRpcHandlers builds handlers that talk to the test's cassette.
+func newRpcHandlers(t *testing.T, opts ...handler.RpcHandlersOption) *handler.RpcHandlers {
+	t.Helper()
+	return handler.NewRpcHandlers(newProvider(t), opts...)
+}
+
+// newProvider serves Spotify from the test's cassette in testdata/spotify.
+// Cassettes replay by default. To re-record them, set SPOTIFY_VCR=record and
+// SPOTIFY_VCR_TOKEN, plus SPOTIFY_API_URL to record against the fake.
+func newProvider(t *testing.T) spotifyservice.Provider {
+	t.Helper()
+	if os.Getenv("SPOTIFY_VCR") == "" {
+		t.Setenv("SPOTIFY_VCR", string(spotifyservice.VCRReplay))
+	}
+	vcr, err := spotifyservice.VCRFromEnv(t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vcr.Provider()
+}
+
+// serve runs an rpc handler with signals as its JSON body, and returns the
+// SSE it wrote.
+func serve[T any](t *testing.T, fn star.StarFunc[T], query url.Values, signals string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/rpc/test?"+query.Encode(), strings.NewReader(signals))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Datastar-Request", "true")
+	rec := httptest.NewRecorder()
+	star.Star(fn)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+var trackIDPattern = regexp.MustCompile(`data-track-id="([^"]*)"`)
+
+// trackIDs lists the track cards in rendered elements, in order.
+func trackIDs(elements string) []string {
+	var ids []string
+	for _, match := range trackIDPattern.FindAllStringSubmatch(elements, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+func TestGetTopSongs(t *testing.T) {
+	h := newRpcHandlers(t)
+
+	body := serve(t, h.GetTopSongs, nil, `{"top_range": "short_term"}`)
+	if !strings.Contains(body, "selector #top-songs") {
+		t.Fatal("want #top-songs patched")
+	}
+	if ids := trackIDs(body); len(ids) != 10 || ids[0] != "track010101" {
+		t.Errorf("top songs: got %v", ids)
+	}
+	if !strings.Contains(body, `"liked_track010101":true`) {
+		t.Error("want the saved state of the top songs")
+	}
+}
+
+func TestGetAlbumInfo(t *testing.T) {
+	h := newRpcHandlers(t)
+
+	body := serve(t, h.GetAlbumInfo, url.Values{"album_id": {"album0301"}}, `{}`)
+	want := []string{"track030101", "track030102", "track030103", "track030104", "track030105"}
+	if ids := trackIDs(body); !slices.Equal(ids, want) {
+		t.Errorf("tracks: got %v, want %v", ids, want)
+	}
+	for _, text := range []string{"Fake Album 3.1", "Fake Artist 3", `"dialog_type":"album"`} {
+		if !strings.Contains(body, text) {
+			t.Errorf("missing %q", text)
+		}
+	}
+}
+
+func TestGetPlaylists(t *testing.T) {
+	h := newRpcHandlers(t)
+
+	body := serve(t, h.GetPlaylists, nil, `{}`)
+	for _, id := range []string{"playlist01", "playlist02", "playlist03"} {
+		if !strings.Contains(body, `data-playlist-id="`+id+`"`) {
+			t.Errorf("missing %s", id)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h := newRpcHandlers(t)
+
+	body := serve(t, h.Search, nil, `{"search_query": "Fake Artist 2"}`)
+	if ids := trackIDs(body); len(ids) != 10 || ids[0] != "track020101" {
+		t.Errorf("tracks: got %v, want artist02's 10 tracks", ids)
+	}
+	if !strings.Contains(body, `data-artist-id="artist02"`) {
+		t.Error("artists: want artist02")
+	}
+}
+
+func TestQueueTrack(t *testing.T) {
+	h := newRpcHandlers(t)
+
+	body := serve(t, h.QueueTrack, url.Values{"track_id": {"track020101"}}, `{}`)
+	if !strings.Contains(body, "Queued Fake Song 2.1.1") {
+		t.Error("want a toast for the queued song")
+	}
+}
+
+// queueUnavailable fails to read the queue, as Spotify does for some
+// accounts and devices.
+type queueUnavailable struct {
+	spotifyservice.Service
+}
+
+func (queueUnavailable) GetQueue(ctx context.Context) (*spotify.Queue, error) {
+	return nil, spotify.Error{Status: http.StatusUnauthorized, Message: "The access token expired"}
+}
+
+func TestGetPlayingSongWithoutQueue(t *testing.T) {
+	provider := newProvider(t)
+	h := handler.NewRpcHandlers(spotifyservice.ProviderFunc(func(r *http.Request) spotifyservice.Service {
+		return queueUnavailable{provider.ForRequest(r)}
+	}))
+
+	body := serve(t, h.GetPlayingSong, nil, `{}`)
+	if strings.Contains(body, "window.location") {
+		t.Error("want no redirect to log in")
+	}
+	if !strings.Contains(body, "Queue unavailable") {
+		t.Error("want the queue panel marked unavailable")
+	}
+	if !strings.Contains(body, "selector #playing-song") {
+		t.Error("want the playing song rendered")
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/thattomperson/spotifgo/internal/ui/components/search"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// SearchSignal holds the header search box and the selected results tab.
+type SearchSignal struct {
+	SearchQuery string `json:"search_query"`
+	SearchTab   string `json:"search_tab"`
+}
+
+const searchLimit = 10
+
+// Search looks up $search_query across tracks, artists, albums and playlists.
+func (h *RpcHandlers) Search(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+
+	query := strings.TrimSpace(signals.SearchQuery)
+	if query == "" {
+		return
+	}
+
+	result, err := spotifyClient.Search(r.Context(), query,
+		spotify.SearchTypeTrack|spotify.SearchTypeArtist|spotify.SearchTypeAlbum|spotify.SearchTypePlaylist,
+		spotify.Limit(searchLimit),
+	)
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to search for %q: %v", query, err)
+		return
+	}
+
+	props := search.ResultsProps{Query: query}
+	var trackIDs []spotify.ID
+	if result.Tracks != nil {
+		props.Tracks = utils.MapSlice(result.Tracks.Tracks, func(item spotify.FullTrack) spotify.SimpleTrack {
+			track := item.SimpleTrack
+			track.Album = item.Album
+			return track
+		})
+		trackIDs = utils.MapSlice(result.Tracks.Tracks, func(item spotify.FullTrack) spotify.ID {
+			return item.ID
+		})
+	}
+	if result.Artists != nil {
+		props.Artists = result.Artists.Artists
+	}
+	if result.Albums != nil {
+		props.Albums = result.Albums.Albums
+	}
+	if result.Playlists != nil {
+		for _, playlist := range result.Playlists.Playlists {
+			// Spotify sends null for playlists it can't show
+			if playlist.ID != "" {
+				props.Playlists = append(props.Playlists, playlist)
+			}
+		}
+	}
+
+	w.ReplaceInner("#search-results", search.Results(props))
+	showSavedState(w, r.Context(), spotifyClient, trackIDs)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"cmp"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/profile"
+	trackcard "github.com/thattomperson/spotifgo/internal/ui/components/track-card"
+	"github.com/thattomperson/spotifgo/internal/utils"
+	"github.com/thattomperson/spotifgo/internal/utils/star"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// TopRangeSignal holds the time range of the "You" column: short_term,
+// medium_term or long_term.
+type TopRangeSignal struct {
+	TopRange string `json:"top_range"`
+}
+
+// maxTopGenres caps how many genres the profile card lists.
+const maxTopGenres = 5
+
+// topRange reads the time range from the signals, defaulting to Spotify's
+// medium term.
+func topRange(signal TopRangeSignal) spotify.Range {
+	switch timeRange := spotify.Range(signal.TopRange); timeRange {
+	case spotify.ShortTermRange, spotify.MediumTermRange, spotify.LongTermRange:
+		return timeRange
+	}
+	return spotify.MediumTermRange
+}
+
+// topGenres ranks genres by how high the artists they belong to rank.
+func topGenres(artists []spotify.FullArtist, n int) []string {
+	scores := map[string]int{}
+	for i, artist := range artists {
+		for _, genre := range artist.Genres {
+			scores[genre] += len(artists) - i
+		}
+	}
+
+	genres := slices.SortedFunc(maps.Keys(scores), func(a, b string) int {
+		return cmp.Or(scores[b]-scores[a], cmp.Compare(a, b))
+	})
+	return genres[:min(len(genres), n)]
+}
+
+// GetTopSongs renders the user's top tracks for $top_range.
+func (h *RpcHandlers) GetTopSongs(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+
+	songs, err := spotifyservice.Collect(spotifyservice.TopTracks(r.Context(), spotifyClient,
+		spotifyservice.WithLimit(h.topTracks),
+		spotifyservice.WithTimeRange(topRange(signals.TopRangeSignal)),
+	))
+	if err != nil {
+		log.Printf("Failed to get top songs: %v", err)
+		showSlowDown(w, err)
+		return
+	}
+
+	if len(songs) == 0 {
+		w.ReplaceInner("#top-songs", trackcard.ListEmpty("Not enough listening history yet"))
+		return
+	}
+	w.ReplaceInner("#top-songs", trackcard.List(trackcard.ListProps{
+		Tracks: utils.MapSlice(songs, func(item spotify.FullTrack) spotify.SimpleTrack {
+			track := item.SimpleTrack
+			track.Album = item.Album
+			return track
+		}),
+	}))
+	showSavedState(w, r.Context(), spotifyClient, utils.MapSlice(songs, func(item spotify.FullTrack) spotify.ID {
+		return item.ID
+	}))
+}
+
+// GetTopArtists renders the user's top artists for $top_range, and the
+// profile card with the genres they add up to.
+func (h *RpcHandlers) GetTopArtists(w *star.DatastarWriter[SpotigoSignals], signals *SpotigoSignals, r *http.Request) {
+	spotifyClient := h.services.ForRequest(r)
+
+	var (
+		wg         sync.WaitGroup
+		artists    []spotify.FullArtist
+		artistsErr error
+	)
+	wg.Go(func() {
+		artists, artistsErr = spotifyservice.Collect(spotifyservice.TopArtists(r.Context(), spotifyClient,
+			spotifyservice.WithLimit(h.topArtists),
+			spotifyservice.WithTimeRange(topRange(signals.TopRangeSignal)),
+		))
+	})
+
+	user, err := spotifyClient.CurrentUser(r.Context())
+	wg.Wait()
+	if err != nil {
+		handleSpotifyError(w, err)
+		log.Printf("Failed to get current user: %v", err)
+		return
+	}
+
+	props := profile.CardProps{
+		Name:      user.DisplayName,
+		Followers: int(user.Followers.Count),
+		Product:   user.Product,
+		Country:   user.Country,
+		URL:       user.ExternalURLs["spotify"],
+	}
+	if len(user.Images) > 0 {
+		props.Image = user.Images[0].URL
+	}
+
+	if artistsErr != nil {
+		log.Printf("Failed to get top artists: %v", artistsErr)
+		showSlowDown(w, artistsErr)
+	} else {
+		props.Genres = topGenres(artists, maxTopGenres)
+		w.ReplaceInner("#top-artists", profile.TopArtists(artists))
+	}
+	w.ReplaceInner("#you", profile.Card(props))
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"net/http"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// recordedResponse captures a handler's output so it can be replayed to every
+// request that joined the same flight.
+type recordedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recordedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *recordedResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *recordedResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+// Flush is a no-op, the recording is sent once the handler returns.
+func (r *recordedResponse) Flush() {}
+
+func (r *recordedResponse) replay(w http.ResponseWriter) {
+	maps.Copy(w.Header(), r.header)
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(r.body.Bytes())
+}
+
+// Coalescer shares one handler run between concurrent identical requests.
+type Coalescer struct {
+	group singleflight.Group
+}
+
+func NewCoalescer() *Coalescer {
+	return &Coalescer{}
+}
+
+// Middleware runs the handler once for all in-flight requests that have the
+// same caller key, method, URL and body, and sends each of them the result.
+func (c *Coalescer) Middleware(keyFn KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body.Close()
+
+			flightKey := requestKey(keyFn, r) + "\n" + r.Method + " " + r.URL.String() + "\n" + string(body)
+
+			result, _, _ := c.group.Do(flightKey, func() (any, error) {
+				// The first caller going away must not cancel the flight for
+				// everyone else waiting on it.
+				shared := r.Clone(context.WithoutCancel(r.Context()))
+				shared.Body = io.NopCloser(bytes.NewReader(body))
+
+				recorder := &recordedResponse{header: http.Header{}}
+				next.ServeHTTP(recorder, shared)
+				return recorder, nil
+			})
+
+			result.(*recordedResponse).replay(w)
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+)
+
+func TestCoalescerSharesIdenticalRequests(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var runs atomic.Int32
+		release := make(chan struct{})
+		handler := NewCoalescer().Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			runs.Add(1)
+			<-release
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write(body)
+		}))
+
+		serve := func(body string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rpc/search", strings.NewReader(body)))
+			return rec
+		}
+
+		var wg sync.WaitGroup
+		recorders := make([]*httptest.ResponseRecorder, 5)
+		for i := range recorders {
+			wg.Go(func() {
+				recorders[i] = serve("same")
+			})
+		}
+		var other *httptest.ResponseRecorder
+		wg.Go(func() {
+			other = serve("other")
+		})
+
+		synctest.Wait()
+		close(release)
+		wg.Wait()
+
+		if got := runs.Load(); got != 2 {
+			t.Errorf("got %d handler runs, want 2", got)
+		}
+		for i, rec := range recorders {
+			if rec.Body.String() != "same" || rec.Header().Get("Content-Type") != "text/event-stream" {
+				t.Errorf("request %d: got %q with %q", i, rec.Body, rec.Header().Get("Content-Type"))
+			}
+		}
+		if other.Body.String() != "other" {
+			t.Errorf("different body: got %q", other.Body)
+		}
+	})
+}
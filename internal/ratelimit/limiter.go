@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// KeyFunc identifies who a request belongs to. An empty key falls back to the
+// client address.
+type KeyFunc func(r *http.Request) string
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter hands out a token bucket per key and forgets keys that have been
+// idle for longer than the idle timeout.
+type Limiter struct {
+	mu          sync.Mutex
+	limit       rate.Limit
+	burst       int
+	idleTimeout time.Duration
+	lastSweep   time.Time
+	entries     map[string]*limiterEntry
+}
+
+func NewLimiter(limit rate.Limit, burst int) *Limiter {
+	return &Limiter{
+		limit:       limit,
+		burst:       burst,
+		idleTimeout: 10 * time.Minute,
+		entries:     map[string]*limiterEntry{},
+	}
+}
+
+// Get returns the token bucket for key, creating it if needed.
+func (l *Limiter) Get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > l.idleTimeout {
+		for k, entry := range l.entries {
+			if now.Sub(entry.lastSeen) > l.idleTimeout {
+				delete(l.entries, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &limiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.entries[key] = entry
+	}
+	entry.lastSeen = now
+	return entry.limiter
+}
+
+// Reserve takes a token for key if one is available now. Otherwise it returns
+// false and how long the caller should wait before trying again.
+func (l *Limiter) Reserve(key string) (bool, time.Duration) {
+	reservation := l.Get(key).Reserve()
+	if !reservation.OK() {
+		return false, time.Second
+	}
+
+	delay := reservation.Delay()
+	if delay == 0 {
+		return true, 0
+	}
+	reservation.Cancel()
+	return false, delay
+}
+
+type middlewareOptions struct {
+	limitedHandler http.Handler
+}
+
+type MiddlewareOption func(*middlewareOptions)
+
+// WithLimitedHandler sets the handler used to respond to limited requests.
+// A Retry-After header is set before it is called.
+func WithLimitedHandler(handler http.Handler) MiddlewareOption {
+	return func(options *middlewareOptions) {
+		options.limitedHandler = handler
+	}
+}
+
+// Middleware rejects requests once the caller's bucket is empty.
+func (l *Limiter) Middleware(keyFn KeyFunc, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	options := &middlewareOptions{
+		limitedHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}),
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter := l.Reserve(requestKey(keyFn, r))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				options.limitedHandler.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func requestKey(keyFn KeyFunc, r *http.Request) string {
+	if keyFn != nil {
+		if key := keyFn(r); key != "" {
+			return key
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
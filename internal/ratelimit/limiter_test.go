@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := NewLimiter(rate.Every(time.Second), 1)
+
+		if ok, _ := l.Reserve("a"); !ok {
+			t.Fatal("first request: want a token")
+		}
+		ok, delay := l.Reserve("a")
+		if ok || delay != time.Second {
+			t.Fatalf("second request: got %v after %v, want limited for 1s", ok, delay)
+		}
+		if ok, _ := l.Reserve("b"); !ok {
+			t.Fatal("other key: want its own bucket")
+		}
+
+		time.Sleep(time.Second)
+		if ok, _ := l.Reserve("a"); !ok {
+			t.Fatal("after refill: want a token")
+		}
+	})
+}
+
+func TestLimiterSweepsIdleKeys(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		l := NewLimiter(rate.Every(time.Second), 1)
+		l.Get("idle")
+		l.Get("active")
+
+		time.Sleep(l.idleTimeout / 2)
+		l.Get("active")
+		time.Sleep(l.idleTimeout/2 + time.Second)
+		l.Get("new")
+
+		if _, ok := l.entries["idle"]; ok {
+			t.Error("idle key: want it swept")
+		}
+		if _, ok := l.entries["active"]; !ok {
+			t.Error("active key: want it kept")
+		}
+		if len(l.entries) != 2 {
+			t.Errorf("got %d entries, want 2", len(l.entries))
+		}
+	})
+}
+
+func TestLimiterMiddleware(t *testing.T) {
+	l := NewLimiter(rate.Every(time.Minute), 1)
+	handler := l.Middleware(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}, WithLimitedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/rpc/search", nil)
+		req.Header.Set("X-User", user)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve("a"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: got %d", rec.Code)
+	}
+	rec := serve("a")
+	if rec.Code != http.StatusTeapot {
+		t.Fatalf("second request: got %d, want the limited handler", rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Retry-After: got %q, want 60", retryAfter)
+	}
+	if rec := serve("b"); rec.Code != http.StatusOK {
+		t.Fatalf("other user: got %d", rec.Code)
+	}
+}
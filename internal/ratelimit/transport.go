@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedError is returned instead of waiting when the upstream API or a
+// local bucket asks us to back off for longer than the transport will block.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+// IsRateLimited reports whether err was caused by rate limiting.
+func IsRateLimited(err error) bool {
+	var rateLimited *RateLimitedError
+	return errors.As(err, &rateLimited)
+}
+
+// Transport is an http.RoundTripper that throttles outgoing requests with a
+// global and a per-user token bucket, honors Retry-After on 429 responses and
+// retries 5xx responses to idempotent requests with jittered exponential
+// backoff.
+type Transport struct {
+	base        http.RoundTripper
+	global      *rate.Limiter
+	perUser     *Limiter
+	maxRetries  int
+	maxWait     time.Duration
+	baseBackoff time.Duration
+}
+
+type TransportOption func(*Transport)
+
+func WithBase(base http.RoundTripper) TransportOption {
+	return func(t *Transport) {
+		t.base = base
+	}
+}
+
+func WithGlobalLimit(limit rate.Limit, burst int) TransportOption {
+	return func(t *Transport) {
+		t.global = rate.NewLimiter(limit, burst)
+	}
+}
+
+func WithUserLimit(limit rate.Limit, burst int) TransportOption {
+	return func(t *Transport) {
+		t.perUser = NewLimiter(limit, burst)
+	}
+}
+
+func WithMaxRetries(retries int) TransportOption {
+	return func(t *Transport) {
+		t.maxRetries = retries
+	}
+}
+
+// WithMaxWait caps how long a single request blocks waiting for a token or a
+// Retry-After. Longer waits fail fast with a RateLimitedError.
+func WithMaxWait(wait time.Duration) TransportOption {
+	return func(t *Transport) {
+		t.maxWait = wait
+	}
+}
+
+func NewTransport(opts ...TransportOption) *Transport {
+	t := &Transport{
+		base:        http.DefaultTransport,
+		global:      rate.NewLimiter(20, 40),
+		perUser:     NewLimiter(5, 10),
+		maxRetries:  3,
+		maxWait:     5 * time.Second,
+		baseBackoff: 250 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// ForUser returns a RoundTripper that also draws from key's bucket.
+func (t *Transport) ForUser(key string) http.RoundTripper {
+	return &userTransport{transport: t, key: key}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.roundTrip(req, "")
+}
+
+type userTransport struct {
+	transport *Transport
+	key       string
+}
+
+func (u *userTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return u.transport.roundTrip(req, u.key)
+}
+
+func (t *Transport) roundTrip(req *http.Request, key string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req.Context(), key); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 {
+			var err error
+			if attemptReq, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+
+		var delay time.Duration
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			delay = retryAfter(resp)
+			if delay > t.maxWait {
+				resp.Body.Close()
+				return nil, &RateLimitedError{RetryAfter: delay}
+			}
+		case resp.StatusCode >= 500:
+			// Spotify may have applied a write before failing, so only
+			// repeat requests that are safe to send twice
+			if !idempotent(req.Method) {
+				return resp, nil
+			}
+			delay = t.backoff(attempt)
+		default:
+			return resp, nil
+		}
+
+		if attempt >= t.maxRetries || !canRewind(req) {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				resp.Body.Close()
+				return nil, &RateLimitedError{RetryAfter: delay}
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// wait takes a token from the global and the user's bucket. Both are
+// reserved before sleeping, so the request waits once for the longer of the
+// two, and fails fast without holding either token when that would take
+// longer than maxWait.
+func (t *Transport) wait(ctx context.Context, key string) error {
+	limiters := []*rate.Limiter{t.global}
+	if key != "" && t.perUser != nil {
+		limiters = append(limiters, t.perUser.Get(key))
+	}
+
+	var reservations []*rate.Reservation
+	cancel := func() {
+		for _, reservation := range reservations {
+			reservation.Cancel()
+		}
+	}
+
+	var delay time.Duration
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+		reservation := limiter.Reserve()
+		if !reservation.OK() {
+			cancel()
+			return &RateLimitedError{RetryAfter: t.maxWait}
+		}
+		reservations = append(reservations, reservation)
+		delay = max(delay, reservation.Delay())
+	}
+
+	if delay > t.maxWait {
+		cancel()
+		return &RateLimitedError{RetryAfter: delay}
+	}
+	if err := sleep(ctx, delay); err != nil {
+		cancel()
+		return err
+	}
+	return nil
+}
+
+// backoff is full-jitter exponential backoff: a random wait in
+// [0, baseBackoff * 2^attempt), capped at maxWait.
+func (t *Transport) backoff(attempt int) time.Duration {
+	ceiling := min(t.baseBackoff<<attempt, t.maxWait)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling)
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return time.Second
+}
+
+// idempotent reports whether sending a request with method twice has the same
+// effect as sending it once, e.g. not adding a track to a playlist twice.
+func idempotent(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func canRewind(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func rewind(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return clone, nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone.Body = body
+	return clone, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
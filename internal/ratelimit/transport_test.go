@@ -0,0 +1,215 @@
+package ratelimit
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// scripted answers each request with the next status, and counts requests.
+func scripted(calls *int, statuses ...int) roundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		status := statuses[min(*calls, len(statuses)-1)]
+		*calls++
+		header := http.Header{}
+		if status == http.StatusTooManyRequests {
+			header.Set("Retry-After", "2")
+		}
+		return &http.Response{StatusCode: status, Header: header, Body: http.NoBody, Request: req}, nil
+	}
+}
+
+func get(t *testing.T, rt http.RoundTripper) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, "https://api.spotify.com/v1/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.RoundTrip(req)
+}
+
+func TestTransportHonorsRetryAfter(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(WithBase(scripted(&calls, http.StatusTooManyRequests, http.StatusOK)))
+
+		start := time.Now()
+		resp, err := get(t, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || calls != 2 {
+			t.Errorf("got %d after %d calls, want 200 after a retry", resp.StatusCode, calls)
+		}
+		if waited := time.Since(start); waited != 2*time.Second {
+			t.Errorf("waited %s, want the 2s Retry-After", waited)
+		}
+	})
+}
+
+func TestTransportFailsFastOnLongRetryAfter(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(WithBase(scripted(&calls, http.StatusTooManyRequests)), WithMaxWait(time.Second))
+
+		start := time.Now()
+		_, err := get(t, transport)
+		var rateLimited *RateLimitedError
+		if !errors.As(err, &rateLimited) || rateLimited.RetryAfter != 2*time.Second {
+			t.Fatalf("got %v, want a RateLimitedError to retry after 2s", err)
+		}
+		if calls != 1 || time.Since(start) != 0 {
+			t.Errorf("got %d calls after %s, want to give up right away", calls, time.Since(start))
+		}
+	})
+}
+
+func TestTransportBacksOffOnServerErrors(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(WithBase(scripted(&calls, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)))
+
+		start := time.Now()
+		resp, err := get(t, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || calls != 3 {
+			t.Errorf("got %d after %d calls, want 200 after two retries", resp.StatusCode, calls)
+		}
+		// Full jitter waits under 250ms, then under 500ms
+		if waited := time.Since(start); waited >= 750*time.Millisecond {
+			t.Errorf("waited %s, want under 750ms", waited)
+		}
+	})
+}
+
+func TestTransportGivesUpAfterMaxRetries(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(WithBase(scripted(&calls, http.StatusInternalServerError)), WithMaxRetries(2))
+
+		resp, err := get(t, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusInternalServerError || calls != 3 {
+			t.Errorf("got %d after %d calls, want the last 500 after 3 calls", resp.StatusCode, calls)
+		}
+	})
+}
+
+func TestTransportRewindsBodyOnRetry(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var bodies []string
+		calls := 0
+		base := scripted(&calls, http.StatusServiceUnavailable, http.StatusOK)
+		transport := NewTransport(WithBase(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			body := new(strings.Builder)
+			if req.Body != nil {
+				_, _ = io.Copy(body, req.Body)
+			}
+			bodies = append(bodies, body.String())
+			return base(req)
+		})))
+
+		req, err := http.NewRequest(http.MethodPut, "https://api.spotify.com/v1/me/player/play", strings.NewReader(`{"uris":["spotify:track:track1"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatal(err)
+		}
+		want := `{"uris":["spotify:track:track1"]}`
+		if len(bodies) != 2 || bodies[0] != want || bodies[1] != want {
+			t.Errorf("got bodies %q, want the body sent on both attempts", bodies)
+		}
+	})
+}
+
+func TestTransportSendsWritesOnceOnServerErrors(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(WithBase(scripted(&calls, http.StatusBadGateway, http.StatusCreated)))
+
+		req, err := http.NewRequest(http.MethodPost, "https://api.spotify.com/v1/playlists/playlist1/tracks", strings.NewReader(`{"uris":["spotify:track:track1"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusBadGateway || calls != 1 {
+			t.Errorf("got %d after %d calls, want the 502 without sending the tracks again", resp.StatusCode, calls)
+		}
+	})
+}
+
+func TestTransportRetriesRateLimitedWrites(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(WithBase(scripted(&calls, http.StatusTooManyRequests, http.StatusCreated)))
+
+		req, err := http.NewRequest(http.MethodPost, "https://api.spotify.com/v1/playlists/playlist1/tracks", strings.NewReader(`{"uris":["spotify:track:track1"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A 429 means Spotify turned the request away, so it's safe to send again
+		if resp.StatusCode != http.StatusCreated || calls != 2 {
+			t.Errorf("got %d after %d calls, want 201 after a retry", resp.StatusCode, calls)
+		}
+	})
+}
+
+func TestTransportChecksBothBucketsBeforeWaiting(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		calls := 0
+		transport := NewTransport(
+			WithBase(scripted(&calls, http.StatusOK)),
+			WithGlobalLimit(rate.Every(3*time.Second), 1),
+			WithUserLimit(rate.Every(10*time.Second), 1),
+			WithMaxWait(5*time.Second),
+		)
+		user := transport.ForUser("user")
+		if _, err := get(t, user); err != nil {
+			t.Fatal(err)
+		}
+
+		// The global bucket refills in 3s, the user's only in 10s
+		start := time.Now()
+		_, err := get(t, user)
+		var rateLimited *RateLimitedError
+		if !errors.As(err, &rateLimited) || rateLimited.RetryAfter != 10*time.Second {
+			t.Fatalf("got %v, want a RateLimitedError to retry after 10s", err)
+		}
+		if waited := time.Since(start); waited != 0 {
+			t.Errorf("waited %s before failing, want no wait", waited)
+		}
+
+		// The failed request gave its global token back
+		start = time.Now()
+		if _, err := get(t, transport); err != nil {
+			t.Fatal(err)
+		}
+		if waited := time.Since(start); waited != 3*time.Second {
+			t.Errorf("next request waited %s, want 3s", waited)
+		}
+	})
+}
@@ -0,0 +1,1465 @@
+package routes_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thattomperson/spotifgo/internal/app"
+	"github.com/thattomperson/spotifgo/internal/config"
+	"github.com/thattomperson/spotifgo/internal/database"
+	"github.com/thattomperson/spotifgo/internal/fakespotify"
+	"github.com/thattomperson/spotifgo/internal/routes"
+	"github.com/zmb3/spotify/v2"
+)
+
+type signals map[string]any
+
+func TestLogin(t *testing.T) {
+	h := newHarness(t)
+
+	noFollow := *h.client
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := noFollow.Get(h.base + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "/auth/login" {
+		t.Fatalf("signed out home: got %d to %q, want redirect to /auth/login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	h.login()
+
+	resp, err = h.client.Get(h.base + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("signed in home: got status %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), `id="playing-song"`) {
+		t.Fatalf("signed in home is missing #playing-song")
+	}
+}
+
+func TestLoginWithBasePath(t *testing.T) {
+	h := newHarness(t, withBasePath("/spotigo"))
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if ids := trackIDs(response.patch("#playing-song")); !slices.Equal(ids, []string{"track010101"}) {
+		t.Fatalf("playing song: got %v", ids)
+	}
+}
+
+func TestAppsWithDifferentBasePaths(t *testing.T) {
+	mounted := newHarness(t, withBasePath("/spotigo"))
+	root := newHarness(t)
+	mounted.login()
+	root.login()
+
+	for _, tc := range []struct {
+		h    *harness
+		want string
+	}{
+		{mounted, "/spotigo/rpc/get-top-songs"},
+		{root, "&#39;/rpc/get-top-songs"},
+	} {
+		resp, err := tc.h.client.Get(tc.h.base + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.Contains(string(body), tc.want) {
+			t.Errorf("home at %s: want rpc urls like %q", tc.h.base, tc.want)
+		}
+	}
+}
+
+func TestSetupRoutesRejectsBadConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.TrustedProxies = []string{"not-a-network"}
+	if err := routes.SetupRoutes(app.NewApp(cfg)); err == nil {
+		t.Fatal("want an error for an invalid trusted proxy")
+	}
+}
+
+func TestDebugVarsOnlyOnAdmin(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	resp, err := h.client.Get(h.base + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("public router: got status %d, want 404", resp.StatusCode)
+	}
+
+	rec := httptest.NewRecorder()
+	h.app.Admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"spotify_cache"`) {
+		t.Errorf("admin router: got status %d without spotify_cache", rec.Code)
+	}
+}
+
+func TestRpcRequiresLogin(t *testing.T) {
+	h := newHarness(t)
+	h.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := h.client.Post(h.base+"/rpc/get-playing-song", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTemporaryRedirect || resp.Header.Get("Location") != "/auth/login" {
+		t.Fatalf("got %d to %q, want redirect to /auth/login", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+func TestGetPlayingSong(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{"current_tab": "currently_playing"})
+
+	if mode := response.mode("#playing-song"); mode != "inner" {
+		t.Errorf("playing song mode: got %s, want inner", mode)
+	}
+	if ids := trackIDs(response.patch("#playing-song")); !slices.Equal(ids, []string{"track010101"}) {
+		t.Errorf("playing song: got %v", ids)
+	}
+	if ids := trackIDs(response.patch("#recent-songs")); len(ids) != 20 {
+		t.Errorf("recent songs: got %d tracks, want 20", len(ids))
+	}
+	if selected := response.signals()["selected_song"]; selected != "track010101" {
+		t.Errorf("selected_song: got %v, want track010101", selected)
+	}
+}
+
+func TestGetPlayingSongShowsPlayedAt(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if recent := response.patch("#recent-songs"); strings.Count(recent, "<time datetime=") != 20 {
+		t.Errorf("recent songs: want a played-at time on every card")
+	}
+	got := response.signals()
+	if newest, oldest := got["recent_newest"].(float64), got["recent_oldest"].(float64); newest == 0 || oldest >= newest {
+		t.Errorf("recent cursors: got newest %v oldest %v", got["recent_newest"], got["recent_oldest"])
+	}
+	if got["recent_more"] != true {
+		t.Errorf("recent_more: got %v, want true", got["recent_more"])
+	}
+}
+
+func TestGetPlayingSongPrependsNewPlays(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	first := h.rpc("get-playing-song", nil, signals{}).signals()
+	shown := signals{"recent_newest": first["recent_newest"], "recent_songs": first["recent_songs"]}
+
+	unchanged := h.rpc("get-playing-song", nil, shown)
+	if slices.Contains(unchanged.selectors(), "#recent-songs") {
+		t.Error("recent songs: want no patch without new plays")
+	}
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.Play("track040101", "")
+	})
+	response := h.rpc("get-playing-song", nil, shown)
+	if mode := response.mode("#recent-songs"); mode != "prepend" {
+		t.Errorf("recent songs mode: got %s, want prepend", mode)
+	}
+	if ids := trackIDs(response.patch("#recent-songs")); !slices.Equal(ids, []string{"track010101"}) {
+		t.Errorf("new plays: got %v, want track010101", ids)
+	}
+	recent, _ := response.signals()["recent_songs"].([]any)
+	if len(recent) != 21 || recent[0] != "track010101" {
+		t.Errorf("recent_songs: got %v, want the new play first", recent)
+	}
+}
+
+func TestGetRecentSongsLoadsMore(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		oldest := state.RecentlyPlayed[len(state.RecentlyPlayed)-1].PlayedAt
+		for i := range 25 {
+			state.RecentlyPlayed = append(state.RecentlyPlayed, fakespotify.Play{
+				TrackID:  "track030101",
+				PlayedAt: oldest.Add(-time.Duration(i+1) * time.Minute),
+			})
+		}
+	})
+
+	got := h.rpc("get-playing-song", nil, signals{}).signals()
+	for _, want := range []int{20, 5} {
+		response := h.rpc("get-recent-songs", nil, signals{
+			"recent_newest": got["recent_newest"],
+			"recent_oldest": got["recent_oldest"],
+			"recent_songs":  got["recent_songs"],
+		})
+		if mode := response.mode("#recent-songs"); mode != "append" {
+			t.Errorf("recent songs mode: got %s, want append", mode)
+		}
+		if ids := trackIDs(response.patch("#recent-songs")); len(ids) != want {
+			t.Errorf("older plays: got %d, want %d", len(ids), want)
+		}
+		maps.Copy(got, response.signals())
+	}
+	if recent, _ := got["recent_songs"].([]any); len(recent) != 45 {
+		t.Errorf("recent_songs: got %d tracks, want 45", len(recent))
+	}
+	if got["recent_more"] != false {
+		t.Errorf("recent_more: got %v, want false", got["recent_more"])
+	}
+}
+
+func TestGetPlayingSongKeepsSelection(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{"selected_song": "track020101"})
+	if selected := response.signals()["selected_song"]; selected != "track020101" {
+		t.Errorf("selected_song: got %v, want track020101", selected)
+	}
+}
+
+func TestGetPlayingSongLeavesNewPlaylistInputs(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{
+		"new_playlist_name":        "Half typed",
+		"new_playlist_description": "Still typing",
+		"new_playlist_source":      "recent",
+	})
+	for _, name := range []string{"new_playlist_name", "new_playlist_description", "new_playlist_source"} {
+		if value, ok := response.signals()[name]; ok {
+			t.Errorf("%s: got patched to %v", name, value)
+		}
+	}
+}
+
+func TestGetPlayingSongLeavesSearch(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{"search_query": "road", "search_tab": "albums"})
+	for _, name := range []string{"search_query", "search_tab"} {
+		if value, ok := response.signals()[name]; ok {
+			t.Errorf("%s: got patched to %v", name, value)
+		}
+	}
+}
+
+func TestGetPlayingSongNothingPlaying(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.Player.TrackID = ""
+	})
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if elements := response.patch("#playing-song"); !strings.Contains(elements, "No song playing") {
+		t.Errorf("playing song: got %q, want the empty card", elements)
+	}
+}
+
+func TestGetPlayingSongShowsControls(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if controls := response.patch("#player-controls"); !strings.Contains(controls, `title="Pause"`) || !strings.Contains(controls, "Fake Laptop") {
+		t.Errorf("player controls: got %q, want pause on Fake Laptop", controls)
+	}
+	if volume := response.signals()["player_volume"]; volume != float64(60) {
+		t.Errorf("player_volume: got %v, want 60", volume)
+	}
+}
+
+func TestGetPlayingSongWithoutDevice(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(deactivateDevices)
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if controls := response.patch("#player-controls"); !strings.Contains(controls, "Choose a device") {
+		t.Errorf("player controls: got %q, want the no device message", controls)
+	}
+}
+
+func TestPlayerPauseAndPlay(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("player-pause", nil, signals{})
+	if controls := response.patch("#player-controls"); !strings.Contains(controls, `title="Play"`) {
+		t.Errorf("player controls: got %q, want a play button", controls)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if state.Player.Playing {
+			t.Error("still playing after pause")
+		}
+	})
+
+	h.rpc("player-play", nil, signals{})
+	h.spotify.Update(func(state *fakespotify.State) {
+		if !state.Player.Playing {
+			t.Error("not playing after play")
+		}
+	})
+}
+
+func TestPlayerNext(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("player-next", nil, signals{})
+	if ids := trackIDs(response.patch("#playing-song")); !slices.Equal(ids, []string{"track010201"}) {
+		t.Errorf("playing song: got %v, want track010201", ids)
+	}
+}
+
+func TestPlayerSeekAndVolume(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	h.rpc("player-seek", nil, signals{"player_progress": 30000})
+	response := h.rpc("player-volume", nil, signals{"player_volume": 25})
+
+	if volume := response.signals()["player_volume"]; volume != float64(25) {
+		t.Errorf("player_volume: got %v, want 25", volume)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if state.Player.ProgressMs != 30000 {
+			t.Errorf("progress: got %d, want 30000", state.Player.ProgressMs)
+		}
+		if volume := state.Player.ActiveDevice().Volume; volume != 25 {
+			t.Errorf("volume: got %d, want 25", volume)
+		}
+	})
+}
+
+func TestPlayerShuffleAndRepeat(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	h.rpc("player-shuffle", url.Values{"state": {"true"}}, signals{})
+	response := h.rpc("player-repeat", url.Values{"state": {"track"}}, signals{})
+
+	if controls := response.patch("#player-controls"); !strings.Contains(controls, `data-repeat="track"`) || !strings.Contains(controls, `aria-pressed="true"`) {
+		t.Errorf("player controls: got %q, want shuffle and repeat track on", controls)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if !state.Player.Shuffle || state.Player.Repeat != "track" {
+			t.Errorf("player: got shuffle %v repeat %q", state.Player.Shuffle, state.Player.Repeat)
+		}
+	})
+}
+
+func TestPlayerNoActiveDevice(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(deactivateDevices)
+
+	response := h.rpc("player-next", nil, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"No active device"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if target := response.redirect(); target != "" {
+		t.Errorf("redirect: got %q, want none", target)
+	}
+}
+
+func TestPlayerPremiumRequired(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.User.Product = "free"
+	})
+
+	response := h.rpc("player-pause", nil, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Premium required"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func deactivateDevices(state *fakespotify.State) {
+	for i := range state.Player.Devices {
+		state.Player.Devices[i].Active = false
+	}
+}
+
+var deviceIDPattern = regexp.MustCompile(`data-device-id="([^"]*)"`)
+
+func TestGetDevices(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-devices", nil, signals{})
+	elements := response.patch("#devices")
+	var ids []string
+	for _, match := range deviceIDPattern.FindAllStringSubmatch(elements, -1) {
+		ids = append(ids, match[1])
+	}
+	if !slices.Equal(ids, []string{"device01", "device02"}) {
+		t.Errorf("devices: got %v", ids)
+	}
+	if strings.Count(elements, ">Playing</span>") != 1 {
+		t.Errorf("devices: got %q, want one playing device", elements)
+	}
+}
+
+func TestPlayerTransfer(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("player-transfer", url.Values{"device_id": {"device02"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Playing on Fake Phone"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if device := response.signals()["player_device"]; device != "device02" {
+		t.Errorf("player_device: got %v, want device02", device)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if device := state.Player.ActiveDevice(); device == nil || device.ID != "device02" {
+			t.Errorf("active device: got %v, want device02", device)
+		}
+	})
+}
+
+func TestPlayerTransferUnknownDevice(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("player-transfer", url.Values{"device_id": {"missing"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Device not found"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if device := state.Player.ActiveDevice(); device == nil || device.ID != "device01" {
+			t.Errorf("active device: got %v, want device01", device)
+		}
+	})
+}
+
+func TestPlayerPlayOnChosenDevice(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		deactivateDevices(state)
+		state.Player.Playing = false
+	})
+
+	response := h.rpc("player-play", nil, signals{"player_device": "device02"})
+	if controls := response.patch("#player-controls"); !strings.Contains(controls, "Fake Phone") {
+		t.Errorf("player controls: got %q, want Fake Phone", controls)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if !state.Player.Playing {
+			t.Error("not playing after play")
+		}
+	})
+}
+
+func TestGetPlayingSongShowsSavedState(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if card := response.patch("#playing-song"); !strings.Contains(card, "toggle-saved-track") {
+		t.Errorf("playing song: got %q, want a heart button", card)
+	}
+	got := response.signals()
+	if liked := got["liked_track010101"]; liked != true {
+		t.Errorf("liked_track010101: got %v, want true", liked)
+	}
+	for _, id := range trackIDs(response.patch("#recent-songs")) {
+		if _, ok := got["liked_"+id]; !ok {
+			t.Errorf("liked_%s: missing", id)
+		}
+	}
+}
+
+func TestExpiredSessionRedirectsToLogin(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.RevokeTokens()
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if target := response.redirect(); target != "/auth/login" {
+		t.Errorf("redirect: got %q, want /auth/login", target)
+	}
+}
+
+func TestQueueTrack(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("queue-track", url.Values{"track_id": {"track020101"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Queued Fake Song 2.1.1"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		if !slices.Equal(state.Queue, []spotify.ID{"track020101"}) {
+			t.Errorf("queue: got %v", state.Queue)
+		}
+	})
+}
+
+func TestQueueTrackConcurrentClicksAllQueue(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			h.rpc("queue-track", url.Values{"track_id": {"track020101"}}, signals{})
+		})
+	}
+	wg.Wait()
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		if len(state.Queue) != 5 {
+			t.Errorf("queue: got %d tracks, want every click queued", len(state.Queue))
+		}
+	})
+}
+
+func TestQueueTrackFromSignals(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("queue-track", nil, signals{"recommended_songs": []string{"track020101", "track020102", "missing"}})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Queued 2/3 songs"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestQueueTrackNothingSelected(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("queue-track", nil, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"No tracks specified"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestQueueTrackShowsQueue(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("queue-track", url.Values{"track_id": {"track020101"}}, signals{"queued_songs": []string{"track010201"}})
+	if queued := response.signals()["queued_songs"]; !reflect.DeepEqual(queued, []any{"track010201", "track020101"}) {
+		t.Errorf("queued_songs: got %v", queued)
+	}
+	if elements := response.patch("#queue"); !slices.Equal(trackIDs(elements), []string{"track020101"}) || strings.Count(elements, "Queued here") != 1 {
+		t.Errorf("queue: got %q, want track020101 highlighted", elements)
+	}
+}
+
+func TestGetPlayingSongShowsQueue(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.Queue = []spotify.ID{"track020101", "track020102"}
+	})
+
+	response := h.rpc("get-playing-song", nil, signals{"queued_songs": []string{"track020102"}})
+	elements := response.patch("#queue")
+	if ids := trackIDs(elements); !slices.Equal(ids, []string{"track020101", "track020102"}) {
+		t.Errorf("queue: got %v", ids)
+	}
+	if count := strings.Count(elements, "Queued here"); count != 1 {
+		t.Errorf("queue: got %d highlighted tracks, want 1", count)
+	}
+}
+
+func TestGetPlayingSongEmptyQueue(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playing-song", nil, signals{})
+	if elements := response.patch("#queue"); !strings.Contains(elements, "Nothing queued") {
+		t.Errorf("queue: got %q, want the empty message", elements)
+	}
+}
+
+func TestQueueTrackNoActiveDevice(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(deactivateDevices)
+
+	response := h.rpc("queue-track", nil, signals{"recent_songs": []string{"track020101", "track020102"}})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"No active device"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestQueueTrackOnChosenDevice(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(deactivateDevices)
+
+	response := h.rpc("queue-track", url.Values{"track_id": {"track020101"}}, signals{"player_device": "device02"})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Queued Fake Song 2.1.1"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+var playlistIDPattern = regexp.MustCompile(`data-playlist-id="([^"]*)"`)
+
+// playlistIDs lists the playlists offered by a rendered playlist picker.
+func playlistIDs(elements string) []string {
+	var ids []string
+	for _, match := range playlistIDPattern.FindAllStringSubmatch(elements, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+func TestChoosePlaylist(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("choose-playlist", url.Values{"track_id": {"track020101"}}, signals{})
+	if ids := playlistIDs(response.patch("#dialog-content")); !slices.Equal(ids, []string{"playlist01", "playlist02"}) {
+		t.Errorf("playlists: got %v, want only the editable ones", ids)
+	}
+	got := response.signals()
+	if got["dialog_open"] != true || got["dialog_type"] != "playlist-picker" {
+		t.Errorf("dialog signals: got %v", got)
+	}
+	if tracks, _ := got["playlist_tracks"].([]any); len(tracks) != 1 || tracks[0] != "track020101" {
+		t.Errorf("playlist_tracks: got %v", got["playlist_tracks"])
+	}
+}
+
+func TestAddToPlaylistWithoutTargetOpensPicker(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("add-to-playlist", url.Values{"track_id": {"track020101"}}, signals{})
+	if toasts := response.toasts(); len(toasts) > 0 {
+		t.Errorf("toasts: got %v, want none", toasts)
+	}
+	if ids := playlistIDs(response.patch("#dialog-content")); len(ids) != 2 {
+		t.Errorf("playlists: got %v", ids)
+	}
+}
+
+func TestAddToChosenPlaylist(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist02"}}, signals{"playlist_tracks": []string{"track020101", "track020102"}})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Added 2 songs to Fake Road Trip"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if open := response.signals()["dialog_open"]; open != false {
+		t.Errorf("dialog_open: got %v, want false", open)
+	}
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		items := state.Playlist("playlist02").Items
+		if len(items) != 12 || items[10].TrackID != "track020101" || items[11].TrackID != "track020102" {
+			t.Errorf("playlist items: got %v", items)
+		}
+	})
+
+	// The playlist just used is offered first next time.
+	response = h.rpc("choose-playlist", url.Values{"track_id": {"track020103"}}, signals{})
+	if ids := playlistIDs(response.patch("#dialog-content")); !slices.Equal(ids, []string{"playlist02", "playlist01"}) {
+		t.Errorf("playlists: got %v, want the recently used one first", ids)
+	}
+}
+
+func TestRememberDefaultPlaylist(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist02"}}, signals{"playlist_tracks": []string{"track020101"}, "playlist_remember": true})
+	h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist01"}}, signals{"playlist_tracks": []string{"track020102"}})
+
+	response := h.rpc("add-to-playlist", url.Values{"track_id": {"track020103"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Added Fake Song 2.1.3 to Fake Road Trip"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+
+	response = h.rpc("choose-playlist", url.Values{"track_id": {"track020104"}}, signals{})
+	elements := response.patch("#dialog-content")
+	if ids := playlistIDs(elements); !slices.Equal(ids, []string{"playlist02", "playlist01"}) {
+		t.Errorf("playlists: got %v, want the default first", ids)
+	}
+	if !strings.Contains(elements, "Default") {
+		t.Errorf("the default playlist isn't marked")
+	}
+}
+
+func TestDefaultPlaylistOutlivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spotifgo.db")
+	withDatabase := func(c *config.Config) {
+		c.DatabasePath = path
+	}
+
+	before := newHarness(t, withDatabase)
+	before.login()
+	before.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist02"}}, signals{"playlist_tracks": []string{"track020101"}, "playlist_remember": true})
+	if err := before.app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new session on a new process still knows the same Spotify user
+	after := newHarness(t, withDatabase)
+	after.login()
+	response := after.rpc("add-to-playlist", url.Values{"track_id": {"track020103"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Added Fake Song 2.1.3 to Fake Road Trip"}) {
+		t.Errorf("toasts: got %v, want the default playlist remembered", toasts)
+	}
+}
+
+func TestAddToPlaylistRejected(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist03"}, "track_id": {"track020101"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Failed to add songs to playlist"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestAddToMissingPlaylist(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("add-to-playlist", url.Values{"playlist_id": {"deleted"}, "track_id": {"track020101"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Playlist not found"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestAddToPlaylistSkipsDuplicates(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist01"}}, signals{"playlist_tracks": []string{"track010101", "track020101", "track030101", "track030101"}})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"1 added, 3 already present"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if elements := response.patch("#toasts"); !strings.Contains(elements, "Add anyway") || !strings.Contains(elements, "allow_duplicates=true") {
+		t.Errorf("toast is missing the add anyway action")
+	}
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		items := state.Playlist("playlist01").Items
+		if len(items) != 4 || items[3].TrackID != "track030101" {
+			t.Errorf("playlist items: got %v", items)
+		}
+	})
+
+	response = h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist01"}, "track_ids[]": {"track010101"}, "allow_duplicates": {"true"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Added Fake Song 1.1.1 to Fake Favourites"}) {
+		t.Errorf("add anyway toasts: got %v", toasts)
+	}
+}
+
+func TestAddToPlaylistSkipsSameRecording(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		track := state.Tracks["track030101"]
+		track.ExternalIDs = state.Tracks["track010101"].ExternalIDs
+		state.Tracks["track030101"] = track
+	})
+
+	response := h.rpc("add-to-playlist", url.Values{"playlist_id": {"playlist01"}, "track_id": {"track030101"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Already in Fake Favourites"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestCreatePlaylist(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("create-playlist", nil, signals{
+		"new_playlist_name":        "Road Mix",
+		"new_playlist_description": "For the drive",
+		"new_playlist_public":      false,
+		"new_playlist_source":      "recent_songs",
+		"recent_songs":             []string{"track010101", "track010102", "missing"},
+		"recommended_songs":        []string{"track040101"},
+	})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Created Road Mix"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if elements := response.patch("#toasts"); !strings.Contains(elements, "https://open.spotify.com/playlist/") {
+		t.Errorf("toast is missing a link to the playlist")
+	}
+	if open := response.signals()["dialog_open"]; open != false {
+		t.Errorf("dialog_open: got %v, want false", open)
+	}
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		playlist := state.Playlists[0]
+		if playlist.Name != "Road Mix" || playlist.Description != "For the drive" || playlist.Public {
+			t.Errorf("playlist: got %+v", playlist)
+		}
+		if len(playlist.Items) != 2 || playlist.Items[0].TrackID != "track010101" || playlist.Items[1].TrackID != "track010102" {
+			t.Errorf("playlist items: got %v", playlist.Items)
+		}
+	})
+}
+
+func TestCreatePlaylistRequiresName(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("create-playlist", nil, signals{"new_playlist_name": " ", "new_playlist_source": "recent_songs", "recent_songs": []string{"track010101"}})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Name required"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestUpdateSelectedSong(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("update-selected-song", nil, signals{"selected_song": "track020101"})
+	if ids := trackIDs(response.patch("#selected-song")); !slices.Equal(ids, []string{"track020101"}) {
+		t.Errorf("selected song: got %v", ids)
+	}
+	recommended := trackIDs(response.patch("#recommended-songs"))
+	if len(recommended) == 0 || slices.Contains(recommended, "track020101") {
+		t.Errorf("recommended songs: got %v", recommended)
+	}
+}
+
+func TestUpdateSelectedSongWithoutRecommendationsAPI(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.RecommendationsDisabled = true
+	})
+
+	response := h.rpc("update-selected-song", nil, signals{"selected_song": "track020101"})
+	recommended := trackIDs(response.patch("#recommended-songs"))
+	if len(recommended) == 0 || slices.Contains(recommended, "track020101") {
+		t.Errorf("recommended songs: got %v", recommended)
+	}
+}
+
+func TestGetTopSongs(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-top-songs", nil, signals{})
+	if ids := trackIDs(response.patch("#top-songs")); len(ids) != 10 || ids[0] != "track010101" {
+		t.Errorf("top songs: got %v", ids)
+	}
+}
+
+func TestGetTopSongsShowsSavedState(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-top-songs", nil, signals{})
+	got := response.signals()
+	for _, id := range trackIDs(response.patch("#top-songs")) {
+		if liked := got["liked_"+id]; liked != true {
+			t.Errorf("liked_%s: got %v, want true", id, liked)
+		}
+	}
+}
+
+func TestGetTopSongsTimeRange(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.TopTracksByRange = map[string][]spotify.ID{"short_term": {"track040101", "track030101"}}
+	})
+
+	response := h.rpc("get-top-songs", nil, signals{"top_range": "short_term"})
+	if ids := trackIDs(response.patch("#top-songs")); !slices.Equal(ids, []string{"track040101", "track030101"}) {
+		t.Errorf("top songs: got %v, want the short term ones", ids)
+	}
+}
+
+func artistIDs(elements string) []string {
+	var ids []string
+	for _, match := range artistIDPattern.FindAllStringSubmatch(elements, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
+
+func TestGetTopArtists(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-top-artists", nil, signals{"top_range": "medium_term"})
+	if ids := artistIDs(response.patch("#top-artists")); !slices.Equal(ids, []string{"artist01", "artist02", "artist03", "artist04"}) {
+		t.Errorf("top artists: got %v", ids)
+	}
+	profile := response.patch("#you")
+	for _, text := range []string{"Fake User", "42 followers", "alternative", "indie rock", "jazz"} {
+		if !strings.Contains(profile, text) {
+			t.Errorf("profile is missing %q", text)
+		}
+	}
+	if strings.Contains(profile, "hip hop") {
+		t.Error("profile: want only the top 5 genres")
+	}
+}
+
+func TestGetTopArtistsTimeRange(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.TopArtistsByRange = map[string][]spotify.ID{"long_term": {"artist04"}}
+	})
+
+	response := h.rpc("get-top-artists", nil, signals{"top_range": "long_term"})
+	if ids := artistIDs(response.patch("#top-artists")); !slices.Equal(ids, []string{"artist04"}) {
+		t.Errorf("top artists: got %v, want artist04", ids)
+	}
+	if profile := response.patch("#you"); !strings.Contains(profile, "hip hop") {
+		t.Errorf("profile: got %q, want artist04's genre", profile)
+	}
+}
+
+func TestTopLimits(t *testing.T) {
+	h := newHarness(t, withTopLimits(3, 2))
+	h.login()
+
+	if ids := trackIDs(h.rpc("get-top-songs", nil, signals{}).patch("#top-songs")); len(ids) != 3 {
+		t.Errorf("top songs: got %v, want 3", ids)
+	}
+	if ids := artistIDs(h.rpc("get-top-artists", nil, signals{}).patch("#top-artists")); len(ids) != 2 {
+		t.Errorf("top artists: got %v, want 2", ids)
+	}
+}
+
+func TestToggleSavedTrack(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	isSaved := func() (saved bool) {
+		h.spotify.Update(func(state *fakespotify.State) {
+			saved = slices.ContainsFunc(state.SavedTracks, func(item fakespotify.Saved) bool {
+				return item.TrackID == "track010102"
+			})
+		})
+		return saved
+	}
+
+	response := h.rpc("toggle-saved-track", url.Values{"track_id": {"track010102"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Added to Liked Songs"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if liked := response.signals()["liked_track010102"]; liked != true {
+		t.Errorf("liked_track010102: got %v, want true", liked)
+	}
+	if !isSaved() {
+		t.Error("track not saved")
+	}
+
+	response = h.rpc("toggle-saved-track", url.Values{"track_id": {"track010102"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Removed from Liked Songs"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if liked := response.signals()["liked_track010102"]; liked != false {
+		t.Errorf("liked_track010102: got %v, want false", liked)
+	}
+	if isSaved() {
+		t.Error("track still saved")
+	}
+}
+
+var artistIDPattern = regexp.MustCompile(`data-artist-id="([^"]*)"`)
+
+func TestSearch(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("search", nil, signals{"search_query": "Fake Artist 2"})
+	elements := response.patch("#search-results")
+	if ids := trackIDs(elements); len(ids) != 10 || ids[0] != "track020101" {
+		t.Errorf("tracks: got %v, want artist02's 10 tracks", ids)
+	}
+	if matches := artistIDPattern.FindAllStringSubmatch(elements, -1); len(matches) != 1 || matches[0][1] != "artist02" {
+		t.Errorf("artists: got %v, want artist02", matches)
+	}
+	if _, ok := response.signals()["liked_track020101"]; !ok {
+		t.Error("liked_track020101: missing")
+	}
+}
+
+func TestSearchPlaylists(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("search", nil, signals{"search_query": "  road trip "})
+	elements := response.patch("#search-results")
+	if ids := playlistIDs(elements); !slices.Equal(ids, []string{"playlist02"}) {
+		t.Errorf("playlists: got %v, want playlist02", ids)
+	}
+	if ids := trackIDs(elements); len(ids) != 0 {
+		t.Errorf("tracks: got %v, want none", ids)
+	}
+}
+
+func TestSearchEmptyQuery(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("search", nil, signals{"search_query": " "})
+	if len(response.Events) != 0 {
+		t.Errorf("events: got %v, want none", response.Events)
+	}
+}
+
+func TestGetDetailedTrackInfo(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-detailed-track-info", url.Values{"track_id": {"track030201"}}, signals{})
+	if elements := response.patch("#dialog-content"); !strings.Contains(elements, "Fake Song 3.2.1") || !strings.Contains(elements, "jazz") {
+		t.Errorf("dialog content is missing the track name or genres")
+	}
+	got := response.signals()
+	if got["dialog_open"] != true || got["dialog_type"] != "track" || got["dialog_item_id"] != "track030201" {
+		t.Errorf("dialog signals: got %v", got)
+	}
+}
+
+var albumIDPattern = regexp.MustCompile(`data-album-id="([^"]*)"`)
+
+func TestGetArtistInfo(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-artist-info", url.Values{"artist_id": {"artist02"}}, signals{})
+	got := response.signals()
+	if got["dialog_open"] != true || got["dialog_type"] != "artist" || got["dialog_item_id"] != "artist02" {
+		t.Errorf("dialog signals: got %v", got)
+	}
+	if _, ok := got["liked_track020101"]; !ok {
+		t.Error("liked_track020101: missing")
+	}
+
+	elements := response.patch("#dialog-content")
+	if !strings.Contains(elements, "Fake Artist 2") || !strings.Contains(elements, "synthpop") {
+		t.Errorf("dialog content is missing the artist name or genres")
+	}
+	if ids := trackIDs(elements); len(ids) != 10 {
+		t.Errorf("top tracks: got %v, want 10", ids)
+	}
+	var albums []string
+	for _, match := range albumIDPattern.FindAllStringSubmatch(elements, -1) {
+		albums = append(albums, match[1])
+	}
+	if !slices.Equal(albums, []string{"album0202", "album0201"}) {
+		t.Errorf("albums: got %v, want album0202 and album0201", albums)
+	}
+	if related := artistIDs(elements); !slices.Equal(related, []string{"artist01", "artist03", "artist04"}) {
+		t.Errorf("related artists: got %v, want the other artists", related)
+	}
+}
+
+func TestPlayerPlayContext(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	h.rpc("player-play", url.Values{"context_uri": {"spotify:album:album0301"}}, signals{})
+	h.spotify.Update(func(state *fakespotify.State) {
+		if state.Player.ContextURI != "spotify:album:album0301" || state.Player.TrackID != "track030101" {
+			t.Errorf("player: got context %q track %q, want album0301's first track", state.Player.ContextURI, state.Player.TrackID)
+		}
+	})
+}
+
+func TestGetAlbumInfo(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-album-info", url.Values{"album_id": {"album0301"}}, signals{})
+	got := response.signals()
+	if got["dialog_open"] != true || got["dialog_type"] != "album" || got["dialog_item_id"] != "album0301" {
+		t.Errorf("dialog signals: got %v", got)
+	}
+
+	elements := response.patch("#dialog-content")
+	want := []string{"track030101", "track030102", "track030103", "track030104", "track030105"}
+	if ids := trackIDs(elements); !slices.Equal(ids, want) {
+		t.Errorf("tracks: got %v, want %v", ids, want)
+	}
+	for _, text := range []string{"Fake Album 3.1", "Fake Artist 3", "2013-01-15", "Label:</strong> Fake Records", "(C) 2013 Fake Artist 3", "Queue album"} {
+		if !strings.Contains(elements, text) {
+			t.Errorf("dialog content is missing %q", text)
+		}
+	}
+}
+
+func TestQueueAlbum(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	tracks := []string{"track030101", "track030102", "track030103"}
+	response := h.rpc("queue-track", url.Values{"track_ids[]": tracks}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Queued 3 songs"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	h.spotify.Update(func(state *fakespotify.State) {
+		if !slices.Equal(state.Queue, []spotify.ID{"track030101", "track030102", "track030103"}) {
+			t.Errorf("queue: got %v", state.Queue)
+		}
+	})
+}
+
+func TestGetPlaylists(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playlists", nil, signals{})
+	elements := response.patch("#playlists")
+	if ids := playlistIDs(elements); !slices.Equal(ids, []string{"playlist01", "playlist02", "playlist03"}) {
+		t.Errorf("playlists: got %v, want all of them", ids)
+	}
+	if strings.Contains(elements, "data-on-intersect") {
+		t.Error("playlists: want no next page")
+	}
+}
+
+func TestGetPlaylistsPaged(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		for i := 4; i <= 25; i++ {
+			state.AddPlaylist(spotify.ID(fmt.Sprintf("playlist%02d", i)), fmt.Sprintf("Fake Playlist %d", i), state.User.ID)
+		}
+	})
+
+	first := h.rpc("get-playlists", nil, signals{})
+	elements := first.patch("#playlists")
+	if ids := playlistIDs(elements); len(ids) != 20 {
+		t.Errorf("first page: got %d playlists, want 20", len(ids))
+	}
+	if !strings.Contains(elements, "offset=20") {
+		t.Error("first page: want a trigger for the next page")
+	}
+
+	second := h.rpc("get-playlists", url.Values{"offset": {"20"}}, signals{})
+	if ids := playlistIDs(second.patch("#playlist-list")); !slices.Equal(ids, []string{"playlist21", "playlist22", "playlist23", "playlist24", "playlist25"}) {
+		t.Errorf("second page: got %v", ids)
+	}
+	if more := second.patch("#playlists-more"); strings.Contains(more, "data-on-intersect") {
+		t.Errorf("second page: got %q, want no next page", more)
+	}
+}
+
+func TestGetPlaylistTracks(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playlist-tracks", url.Values{"playlist_id": {"playlist01"}}, signals{})
+	got := response.signals()
+	if got["browse_playlist"] != "playlist01" || got["current_tab"] != "playlists" {
+		t.Errorf("signals: got %v", got)
+	}
+	if _, ok := got["liked_track010101"]; !ok {
+		t.Error("liked_track010101: missing")
+	}
+	elements := response.patch("#playlist-view")
+	if !strings.Contains(elements, "Fake Favourites") || !strings.Contains(elements, "3 songs") {
+		t.Errorf("playlist view is missing the playlist name or song count")
+	}
+	if ids := trackIDs(elements); !slices.Equal(ids, []string{"track010101", "track010201", "track020101"}) {
+		t.Errorf("tracks: got %v", ids)
+	}
+}
+
+func TestGetPlaylistTracksPaged(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+	var want []string
+	h.spotify.Update(func(state *fakespotify.State) {
+		ids := slices.Sorted(maps.Keys(state.Tracks))
+		state.AddPlaylist("playlist04", "Everything", state.User.ID, ids...)
+		for _, id := range ids[20:] {
+			want = append(want, id.String())
+		}
+	})
+
+	first := h.rpc("get-playlist-tracks", url.Values{"playlist_id": {"playlist04"}}, signals{})
+	if ids := trackIDs(first.patch("#playlist-view")); len(ids) != 20 {
+		t.Errorf("first page: got %d tracks, want 20", len(ids))
+	}
+
+	second := h.rpc("get-playlist-tracks", url.Values{"playlist_id": {"playlist04"}, "offset": {"20"}}, signals{})
+	if ids := trackIDs(second.patch("#playlist-tracks")); !slices.Equal(ids, want) {
+		t.Errorf("second page: got %v, want %v", ids, want)
+	}
+	if page := second.patch("#playlist-tracks"); strings.Contains(page, `class="flex flex-col gap-3"`) {
+		t.Error("second page: want bare cards, got them wrapped in a list")
+	}
+	if _, ok := second.signals()["browse_playlist"]; ok {
+		t.Error("second page: want the open playlist left alone")
+	}
+}
+
+func TestGetPlaylistTracksMissing(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	response := h.rpc("get-playlist-tracks", url.Values{"playlist_id": {"missing"}}, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Playlist not found"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
+
+func TestGetHistoryDisabled(t *testing.T) {
+	h := newHarness(t)
+	h.login()
+
+	history := h.rpc("get-history", nil, signals{}).patch("#history")
+	if !strings.Contains(history, "isn&#39;t enabled") {
+		t.Errorf("history: want the disabled notice, got %s", history)
+	}
+}
+
+var historyTotalPattern = regexp.MustCompile(`data-history-total="(\d+)"`)
+
+// historyTotal reads how many plays the history says match its filters.
+func historyTotal(elements string) string {
+	match := historyTotalPattern.FindStringSubmatch(elements)
+	if match == nil {
+		return "0"
+	}
+	return match[1]
+}
+
+func TestEnableHistory(t *testing.T) {
+	h := newHarness(t, withHistory(t, time.Hour))
+	h.login()
+
+	if consent := h.rpc("get-history", nil, signals{}).patch("#history"); !strings.Contains(consent, "enable-history") {
+		t.Fatalf("history: want the opt-in, got %s", consent)
+	}
+
+	response := h.rpc("enable-history", nil, signals{})
+	history := response.patch("#history")
+	if total := historyTotal(history); total != "20" {
+		t.Errorf("history total: got %s, want 20", total)
+	}
+	if ids := trackIDs(history); len(ids) != 20 || ids[0] != "track010101" {
+		t.Errorf("history: got %v, want the 20 recent plays newest first", ids)
+	}
+	if !strings.Contains(history, "<time datetime=") {
+		t.Error("history: want played-at times")
+	}
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Keeping your listening history"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+
+	again := h.rpc("get-history", nil, signals{}).patch("#history")
+	if !slices.Equal(trackIDs(again), trackIDs(history)) {
+		t.Errorf("history: got %v after reload, want %v", trackIDs(again), trackIDs(history))
+	}
+}
+
+func TestHistoryCollectsNewPlays(t *testing.T) {
+	h := newHarness(t, withHistory(t, 20*time.Millisecond))
+	h.login()
+	h.rpc("enable-history", nil, signals{})
+
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.Play("track040101", "")
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		plays := h.rpc("get-history-plays", nil, signals{}).patch("#history-plays-view")
+		if total := historyTotal(plays); total == "21" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("history total: got %s, want 21 once the collector polls", total)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestHistoryKeptPrivate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	h := newHarness(t, func(c *config.Config) {
+		c.DatabasePath = path
+		c.HistoryInterval = 20 * time.Millisecond
+	})
+	h.login()
+	h.rpc("enable-history", nil, signals{})
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("database permissions: got %v, want -rw-------", perm)
+	}
+
+	if err := h.app.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is collected once the app shut down
+	h.spotify.Update(func(state *fakespotify.State) {
+		state.Play("track040101", "")
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var token string
+	var plays int
+	if err := db.QueryRow(`SELECT token, (SELECT COUNT(*) FROM plays) FROM users`).Scan(&token, &plays); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(token, "fake-refresh-") || strings.Contains(token, "refresh_token") {
+		t.Errorf("token: stored in plaintext as %s", token)
+	}
+	if plays != 20 {
+		t.Errorf("plays: got %d, want 20 as the collector stopped", plays)
+	}
+}
+
+func TestHistoryDateFilter(t *testing.T) {
+	h := newHarness(t, withHistory(t, time.Hour))
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		for _, playedAt := range []string{"2024-03-11T00:30:00Z", "2024-03-10T12:00:00Z", "2024-03-09T23:30:00Z"} {
+			at, _ := time.Parse(time.RFC3339, playedAt)
+			state.RecentlyPlayed = append(state.RecentlyPlayed, fakespotify.Play{TrackID: "track030101", PlayedAt: at})
+		}
+	})
+	h.rpc("enable-history", nil, signals{})
+
+	tests := []struct {
+		name     string
+		signals  signals
+		expected string
+	}{
+		{"all time", signals{}, "23"},
+		{"one day in UTC", signals{"history_from": "2024-03-10", "history_to": "2024-03-10", "history_tz": "UTC"}, "1"},
+		{"one day in Adelaide", signals{"history_from": "2024-03-10", "history_to": "2024-03-10", "history_tz": "Australia/Adelaide"}, "2"},
+		{"up to a day", signals{"history_to": "2024-03-10", "history_tz": "UTC"}, "2"},
+		{"unknown time zone", signals{"history_from": "2024-03-10", "history_to": "2024-03-10", "history_tz": "Nowhere/Else"}, "1"},
+		{"before any plays", signals{"history_to": "2020-01-01"}, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plays := h.rpc("get-history-plays", nil, tt.signals).patch("#history-plays-view")
+			if total := historyTotal(plays); total != tt.expected {
+				t.Errorf("history total: got %s, want %s", total, tt.expected)
+			}
+			if tt.expected == "0" && !strings.Contains(plays, "No plays in this period") {
+				t.Errorf("history: want the empty message, got %s", plays)
+			}
+		})
+	}
+}
+
+func TestHistoryPaged(t *testing.T) {
+	h := newHarness(t, withHistory(t, time.Hour))
+	h.login()
+	h.spotify.Update(func(state *fakespotify.State) {
+		oldest := state.RecentlyPlayed[len(state.RecentlyPlayed)-1].PlayedAt
+		for i := range 30 {
+			state.RecentlyPlayed = append(state.RecentlyPlayed, fakespotify.Play{
+				TrackID:  "track030101",
+				PlayedAt: oldest.Add(-time.Duration(i+1) * time.Minute),
+			})
+		}
+	})
+
+	first := h.rpc("enable-history", nil, signals{}).patch("#history")
+	if total := historyTotal(first); total != "50" {
+		t.Errorf("history total: got %s, want 50", total)
+	}
+	if ids := trackIDs(first); len(ids) != 20 {
+		t.Errorf("first page: got %d plays, want 20", len(ids))
+	}
+
+	for _, page := range []struct {
+		offset   string
+		expected int
+		more     bool
+	}{{"20", 20, true}, {"40", 10, false}} {
+		response := h.rpc("get-history-plays", url.Values{"offset": {page.offset}}, signals{})
+		if mode := response.mode("#history-plays"); mode != "append" {
+			t.Errorf("offset %s: got mode %s, want append", page.offset, mode)
+		}
+		if ids := trackIDs(response.patch("#history-plays")); len(ids) != page.expected {
+			t.Errorf("offset %s: got %d plays, want %d", page.offset, len(ids), page.expected)
+		}
+		if more := strings.Contains(response.patch("#history-more"), "get-history-plays"); more != page.more {
+			t.Errorf("offset %s: got more %v, want %v", page.offset, more, page.more)
+		}
+	}
+}
+
+func TestDisableHistory(t *testing.T) {
+	h := newHarness(t, withHistory(t, time.Hour))
+	h.login()
+	h.rpc("enable-history", nil, signals{})
+
+	response := h.rpc("disable-history", nil, signals{})
+	if consent := response.patch("#history"); !strings.Contains(consent, "enable-history") {
+		t.Errorf("history: want the opt-in after opting out, got %s", consent)
+	}
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Listening history deleted"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+	if plays := h.rpc("get-history-plays", nil, signals{}).patch("#history-plays-view"); historyTotal(plays) != "0" {
+		t.Errorf("history: want nothing kept after opting out, got %s plays", historyTotal(plays))
+	}
+}
+
+func TestRpcRateLimit(t *testing.T) {
+	h := newHarness(t, withRpcRateLimit(0.001, 1))
+	h.login()
+
+	h.rpc("get-top-songs", nil, signals{})
+	response := h.rpc("get-top-songs", nil, signals{})
+	if toasts := response.toasts(); !slices.Equal(toasts, []string{"Slow down"}) {
+		t.Errorf("toasts: got %v", toasts)
+	}
+}
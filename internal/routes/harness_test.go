@@ -0,0 +1,308 @@
+package routes_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thattomperson/spotifgo/internal/app"
+	"github.com/thattomperson/spotifgo/internal/config"
+	"github.com/thattomperson/spotifgo/internal/fakespotify"
+	"github.com/thattomperson/spotifgo/internal/routes"
+)
+
+// harness runs the whole app on an httptest.Server against a fake Spotify.
+type harness struct {
+	t       *testing.T
+	app     *app.App
+	spotify *fakespotify.Server
+	server  *httptest.Server
+	client  *http.Client
+	base    string
+}
+
+type harnessOption func(*config.Config)
+
+func withBasePath(basePath string) harnessOption {
+	return func(c *config.Config) {
+		c.BasePath = basePath
+	}
+}
+
+func withRpcRateLimit(limit float64, burst int) harnessOption {
+	return func(c *config.Config) {
+		c.RpcRateLimit = limit
+		c.RpcRateBurst = burst
+	}
+}
+
+func withTopLimits(tracks int, artists int) harnessOption {
+	return func(c *config.Config) {
+		c.TopTracksLimit = tracks
+		c.TopArtistsLimit = artists
+	}
+}
+
+// withHistory keeps listening history in a fresh database, polled every
+// interval.
+func withHistory(t *testing.T, interval time.Duration) harnessOption {
+	path := filepath.Join(t.TempDir(), "history.db")
+	return func(c *config.Config) {
+		c.DatabasePath = path
+		c.HistoryInterval = interval
+	}
+}
+
+func newHarness(t *testing.T, opts ...harnessOption) *harness {
+	t.Helper()
+
+	fake := fakespotify.New()
+	spotifyServer := httptest.NewServer(fake)
+	t.Cleanup(spotifyServer.Close)
+
+	cfg := config.NewConfig()
+	cfg.BasePath = ""
+	cfg.SpotifyClientID = "client-id"
+	cfg.SpotifyClientSecret = "client-secret"
+	cfg.SpotifyAccountsURL = spotifyServer.URL
+	cfg.SpotifyAPIURL = spotifyServer.URL + "/v1/"
+	cfg.TrustedProxies = nil
+	cfg.RpcRateLimit = 1000
+	cfg.RpcRateBurst = 1000
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	application := app.NewApp(cfg)
+	// Registered first so it runs last, once the server stopped taking requests
+	t.Cleanup(func() {
+		if err := application.Shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	})
+	server := httptest.NewServer(application.Router)
+	t.Cleanup(server.Close)
+
+	cfg.Host = server.URL
+	cfg.SpotifyRedirectURL = server.URL + cfg.BasePath + "/auth/callback"
+	if err := routes.SetupRoutes(application); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &harness{
+		t:       t,
+		app:     application,
+		spotify: fake,
+		server:  server,
+		client:  &http.Client{Jar: jar},
+		base:    server.URL + cfg.BasePath,
+	}
+}
+
+// login goes through the OAuth flow: the app redirects to the fake's
+// authorize endpoint, which bounces straight back to the callback.
+func (h *harness) login() {
+	h.t.Helper()
+	resp, err := h.client.Get(h.base + "/auth/login")
+	if err != nil {
+		h.t.Fatalf("login: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		h.t.Fatalf("login: got status %d", resp.StatusCode)
+	}
+	if resp.Request.URL.Path != strings.TrimPrefix(h.base, h.server.URL)+"/" {
+		h.t.Fatalf("login: ended up at %s", resp.Request.URL)
+	}
+}
+
+// rpc posts signals to an /rpc endpoint and parses the SSE response.
+func (h *harness) rpc(method string, query url.Values, signals any) *sseResponse {
+	h.t.Helper()
+	body, err := json.Marshal(signals)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	target := h.base + "/rpc/" + method
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Datastar-Request", "true")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatalf("rpc %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		h.t.Fatalf("rpc %s: got status %d", method, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		h.t.Fatalf("rpc %s: got content type %q", method, contentType)
+	}
+	return parseSSE(h.t, resp.Body)
+}
+
+// sseEvent is one datastar event with its data lines grouped by key, e.g.
+// "selector", "mode", "elements" or "signals".
+type sseEvent struct {
+	Type string
+	Data map[string]string
+}
+
+type sseResponse struct {
+	t      *testing.T
+	Events []sseEvent
+}
+
+func parseSSE(t *testing.T, r io.Reader) *sseResponse {
+	t.Helper()
+	response := &sseResponse{t: t}
+	event := sseEvent{Data: map[string]string{}}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.Type != "" {
+				response.Events = append(response.Events, event)
+			}
+			event = sseEvent{Data: map[string]string{}}
+		case strings.HasPrefix(line, "event: "):
+			event.Type = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			key, value, _ := strings.Cut(strings.TrimPrefix(line, "data: "), " ")
+			if existing, ok := event.Data[key]; ok {
+				value = existing + "\n" + value
+			}
+			event.Data[key] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("reading SSE stream: %v", err)
+	}
+	if event.Type != "" {
+		response.Events = append(response.Events, event)
+	}
+	return response
+}
+
+// patch returns the elements patched into selector, failing the test if there
+// is no such patch.
+func (s *sseResponse) patch(selector string) string {
+	s.t.Helper()
+	for _, event := range s.Events {
+		if event.Type == "datastar-patch-elements" && event.Data["selector"] == selector {
+			return event.Data["elements"]
+		}
+	}
+	s.t.Fatalf("no patch for %s in %v", selector, s.selectors())
+	return ""
+}
+
+// mode returns the patch mode used for selector, "outer" being the default.
+func (s *sseResponse) mode(selector string) string {
+	s.t.Helper()
+	for _, event := range s.Events {
+		if event.Type == "datastar-patch-elements" && event.Data["selector"] == selector {
+			if mode := event.Data["mode"]; mode != "" {
+				return mode
+			}
+			return "outer"
+		}
+	}
+	s.t.Fatalf("no patch for %s in %v", selector, s.selectors())
+	return ""
+}
+
+func (s *sseResponse) selectors() []string {
+	var selectors []string
+	for _, event := range s.Events {
+		if selector := event.Data["selector"]; selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+	return selectors
+}
+
+// signals merges every signal patch in the stream.
+func (s *sseResponse) signals() map[string]any {
+	s.t.Helper()
+	merged := map[string]any{}
+	for _, event := range s.Events {
+		if event.Type != "datastar-patch-signals" {
+			continue
+		}
+		if err := json.Unmarshal([]byte(event.Data["signals"]), &merged); err != nil {
+			s.t.Fatalf("parsing signals: %v", err)
+		}
+	}
+	return merged
+}
+
+var toastTitlePattern = regexp.MustCompile(`<p class="text-sm font-semibold truncate">(.*?)</p>`)
+
+// toasts returns the titles of every toast appended in the stream.
+func (s *sseResponse) toasts() []string {
+	var titles []string
+	for _, event := range s.Events {
+		if event.Data["selector"] != "#toasts" {
+			continue
+		}
+		for _, match := range toastTitlePattern.FindAllStringSubmatch(event.Data["elements"], -1) {
+			titles = append(titles, html.UnescapeString(match[1]))
+		}
+	}
+	return titles
+}
+
+// redirect returns where a datastar redirect script sends the browser.
+func (s *sseResponse) redirect() string {
+	for _, event := range s.Events {
+		elements := event.Data["elements"]
+		if _, rest, ok := strings.Cut(elements, "window.location.href = "); ok {
+			target, _, _ := strings.Cut(html.UnescapeString(rest), ")")
+			return strings.Trim(target, `"`)
+		}
+	}
+	return ""
+}
+
+var trackIDPattern = regexp.MustCompile(`data-track-id="([^"]*)"`)
+
+// trackIDs lists the track cards in rendered elements, in order.
+func trackIDs(elements string) []string {
+	var ids []string
+	for _, match := range trackIDPattern.FindAllStringSubmatch(elements, -1) {
+		ids = append(ids, match[1])
+	}
+	return ids
+}
@@ -1,26 +1,91 @@
 package routes
 
 import (
+	"expvar"
+	"log"
 	"net/http"
 	"os"
 
 	"github.com/thattomperson/spotifgo/internal/app"
 	"github.com/thattomperson/spotifgo/internal/auth"
+	"github.com/thattomperson/spotifgo/internal/database"
 	"github.com/thattomperson/spotifgo/internal/handler"
+	"github.com/thattomperson/spotifgo/internal/ratelimit"
+	"github.com/thattomperson/spotifgo/internal/services/history"
+	"github.com/thattomperson/spotifgo/internal/services/preferences"
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
 	"github.com/thattomperson/spotifgo/internal/ui/pages"
+	"github.com/thattomperson/spotifgo/internal/utils"
 	"github.com/thattomperson/spotifgo/internal/utils/star"
 
 	"github.com/a-h/templ"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/time/rate"
 )
 
-func SetupRoutes(app *app.App) {
-	authenticator := auth.NewAuthenticator(app.Config.SpotifyRedirectURL, app.Config.SpotifyClientID, app.Config.SpotifyClientSecret)
+// SetupRoutes mounts the app's routes on app.Router under the configured
+// base path, returning an error when the configuration can't be used.
+func SetupRoutes(app *app.App) error {
+	basePath := app.Config.BasePath
+
+	trustedProxies, err := utils.ParseTrustedProxies(app.Config.TrustedProxies)
+	if err != nil {
+		return err
+	}
+
+	var authenticatorOptions []auth.AuthenticatorOption
+	if app.Config.SpotifyAccountsURL != "" {
+		authenticatorOptions = append(authenticatorOptions, auth.WithAccountsURL(app.Config.SpotifyAccountsURL))
+	}
+
+	authenticator := auth.NewAuthenticator(app.Config.SpotifyRedirectURL, app.Config.SpotifyClientID, app.Config.SpotifyClientSecret, authenticatorOptions...)
 	tokenAuth := auth.NewTokenAuth(app.Config.TokenSecret)
-	authService := auth.NewAuth(authenticator, tokenAuth)
+	authService := auth.NewAuth(authenticator, tokenAuth,
+		auth.WithTrustedProxies(trustedProxies),
+		auth.WithAPIURL(app.Config.SpotifyAPIURL),
+		auth.WithTransport(ratelimit.NewTransport(
+			ratelimit.WithGlobalLimit(rate.Limit(app.Config.SpotifyRateLimit), app.Config.SpotifyRateBurst),
+			ratelimit.WithUserLimit(rate.Limit(app.Config.SpotifyUserRateLimit), app.Config.SpotifyUserRateBurst),
+		)),
+	)
+
+	caches := spotifyservice.NewCaches()
+	caches.Publish("spotify_cache")
+	services := spotifyservice.NewCachingProvider(spotifyservice.NewProvider(authService.GetSpotifyClient), caches, authService.UserKey)
+
+	db, err := database.Open(app.Config.DatabasePath)
+	if err != nil {
+		return err
+	}
+	app.CloseOnShutdown(db)
+	preferenceStore, err := preferences.NewStore(db)
+	if err != nil {
+		return err
+	}
+
+	rpcOptions := []handler.RpcHandlersOption{
+		handler.WithPreferences(preferenceStore),
+		handler.WithToken(authService.Token),
+		handler.WithDedupeByISRC(app.Config.PlaylistDedupeISRC),
+		handler.WithTopLimits(app.Config.TopTracksLimit, app.Config.TopArtistsLimit),
+	}
+	// History needs a database that outlives the process to be worth collecting
+	if app.Config.DatabasePath != "" {
+		historyStore, err := history.NewStore(db, app.Config.TokenSecret)
+		if err != nil {
+			return err
+		}
+		collector := history.NewCollector(historyStore, authService, history.WithInterval(app.Config.HistoryInterval))
+		app.Go(collector.Run)
+		log.Printf("listening history: %s every %s", app.Config.DatabasePath, app.Config.HistoryInterval)
+		rpcOptions = append(rpcOptions, handler.WithHistory(historyStore, collector))
+	}
+	rpcHandlers := handler.NewRpcHandlers(services, rpcOptions...)
 
 	r := chi.NewRouter()
+	r.Use(utils.BasePathMiddleware(basePath))
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
@@ -35,21 +100,72 @@ func SetupRoutes(app *app.App) {
 
 		r.Get("/", templ.Handler(pages.HomePage(handler.SpotigoSignals{
 			CurrentTab: "currently_playing",
+			SearchSignal: handler.SearchSignal{
+				SearchTab: "tracks",
+			},
+			TopRangeSignal: handler.TopRangeSignal{
+				TopRange: "medium_term",
+			},
 		})).ServeHTTP)
 
-		rpcHandlers := handler.NewRpcHandlers(authService)
+		rpcLimiter := ratelimit.NewLimiter(rate.Limit(app.Config.RpcRateLimit), app.Config.RpcRateBurst)
+		rpcCoalescer := ratelimit.NewCoalescer()
+
+		r.Route("/rpc", func(r chi.Router) {
+			r.Use(rpcLimiter.Middleware(authService.UserKey, ratelimit.WithLimitedHandler(
+				star.ToastHandler("Slow down", "Too many requests, please wait a moment.", star.WithVariant(toast.VariantWarning)),
+			)))
+			// Identical reads in flight share one run. Mutations never do,
+			// since two clicks on "queue" should queue twice.
+			r.Group(func(r chi.Router) {
+				r.Use(rpcCoalescer.Middleware(authService.UserKey))
 
-		r.Post("/rpc/get-playing-song", star.Star(rpcHandlers.GetPlayingSong))
-		r.Post("/rpc/queue-track", star.Star(rpcHandlers.QueueTrack))
-		r.Post("/rpc/add-to-playlist", star.Star(rpcHandlers.AddToPlaylist))
-		r.Post("/rpc/update-selected-song", star.Star(rpcHandlers.UpdateSelectedSong))
-		r.Post("/rpc/get-top-songs", star.Star(rpcHandlers.GetTopSongs))
-		r.Post("/rpc/get-detailed-track-info", star.Star(rpcHandlers.GetDetailedTrackInfo))
+				r.Post("/get-playing-song", star.Star(rpcHandlers.GetPlayingSong))
+				r.Post("/get-devices", star.Star(rpcHandlers.GetDevices))
+				r.Post("/choose-playlist", star.Star(rpcHandlers.ChoosePlaylist))
+				r.Post("/update-selected-song", star.Star(rpcHandlers.UpdateSelectedSong))
+				r.Post("/get-top-songs", star.Star(rpcHandlers.GetTopSongs))
+				r.Post("/get-top-artists", star.Star(rpcHandlers.GetTopArtists))
+				r.Post("/get-recent-songs", star.Star(rpcHandlers.GetRecentSongs))
+				r.Post("/search", star.Star(rpcHandlers.Search))
+				r.Post("/get-detailed-track-info", star.Star(rpcHandlers.GetDetailedTrackInfo))
+				r.Post("/get-artist-info", star.Star(rpcHandlers.GetArtistInfo))
+				r.Post("/get-album-info", star.Star(rpcHandlers.GetAlbumInfo))
+				r.Post("/get-playlists", star.Star(rpcHandlers.GetPlaylists))
+				r.Post("/get-playlist-tracks", star.Star(rpcHandlers.GetPlaylistTracks))
+				r.Post("/get-history", star.Star(rpcHandlers.GetHistory))
+				r.Post("/get-history-plays", star.Star(rpcHandlers.GetHistoryPlays))
+			})
+
+			r.Post("/queue-track", star.Star(rpcHandlers.QueueTrack))
+			r.Post("/player-play", star.Star(rpcHandlers.PlayerPlay))
+			r.Post("/player-pause", star.Star(rpcHandlers.PlayerPause))
+			r.Post("/player-next", star.Star(rpcHandlers.PlayerNext))
+			r.Post("/player-previous", star.Star(rpcHandlers.PlayerPrevious))
+			r.Post("/player-seek", star.Star(rpcHandlers.PlayerSeek))
+			r.Post("/player-shuffle", star.Star(rpcHandlers.PlayerShuffle))
+			r.Post("/player-repeat", star.Star(rpcHandlers.PlayerRepeat))
+			r.Post("/player-volume", star.Star(rpcHandlers.PlayerVolume))
+			r.Post("/player-transfer", star.Star(rpcHandlers.PlayerTransfer))
+			r.Post("/add-to-playlist", star.Star(rpcHandlers.AddToPlaylist))
+			r.Post("/create-playlist", star.Star(rpcHandlers.CreatePlaylist))
+			r.Post("/toggle-saved-track", star.Star(rpcHandlers.ToggleSavedTrack))
+			r.Post("/enable-history", star.Star(rpcHandlers.EnableHistory))
+			r.Post("/disable-history", star.Star(rpcHandlers.DisableHistory))
+		})
 
 		r.Get("/auth/logout", authService.LogoutHandler)
 	})
 
-	r.Get("/assets/*", http.StripPrefix("/assets", http.FileServer(http.FS(os.DirFS("assets")))).ServeHTTP)
+	r.Get("/assets/*", http.StripPrefix(utils.JoinPath(basePath, "/assets"), http.FileServer(http.FS(os.DirFS("assets")))).ServeHTTP)
+
+	app.Admin.Get("/debug/vars", expvar.Handler().ServeHTTP)
 
-	app.Router.Mount("/", r)
+	mountPath := "/"
+	if basePath != "" {
+		mountPath = basePath
+		app.Router.Get("/", http.RedirectHandler(utils.JoinPath(basePath, "/"), http.StatusTemporaryRedirect).ServeHTTP)
+	}
+	app.Router.Mount(mountPath, r)
+	return nil
 }
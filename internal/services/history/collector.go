@@ -0,0 +1,104 @@
+package history
+
+import (
+	"context"
+	"log"
+	"time"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// pollLimit is the most plays Spotify returns from recently played, and all
+// it remembers.
+const pollLimit = 50
+
+// Clients builds Spotify clients from stored tokens, outside of any request,
+// e.g. auth.Auth.
+type Clients interface {
+	// TokenSource refreshes token once it expires.
+	TokenSource(ctx context.Context, token *oauth2.Token) oauth2.TokenSource
+	ClientForToken(ctx context.Context, token *oauth2.Token) *spotify.Client
+}
+
+// Collector periodically copies the recently played tracks of every user who
+// opted in into the Store, so their history outlives Spotify's 50 play
+// window.
+type Collector struct {
+	store    *Store
+	clients  Clients
+	interval time.Duration
+}
+
+type CollectorOption func(*Collector)
+
+// WithInterval sets how often every user's recently played is polled. It
+// should stay well under the time it takes to play 50 tracks.
+func WithInterval(interval time.Duration) CollectorOption {
+	return func(c *Collector) {
+		c.interval = interval
+	}
+}
+
+func NewCollector(store *Store, clients Clients, opts ...CollectorOption) *Collector {
+	c := &Collector{
+		store:    store,
+		clients:  clients,
+		interval: 30 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Run polls every interval until ctx is done.
+func (c *Collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CollectAll(ctx)
+		}
+	}
+}
+
+// CollectAll polls every user who opted in, logging failures so one user's
+// revoked token doesn't hold up the rest.
+func (c *Collector) CollectAll(ctx context.Context) {
+	users, err := c.store.Users(ctx)
+	if err != nil {
+		log.Printf("Failed to list history users: %v", err)
+		return
+	}
+	for _, user := range users {
+		if _, err := c.Collect(ctx, user); err != nil {
+			log.Printf("Failed to collect history for %s: %v", user.ID, err)
+		}
+	}
+}
+
+// Collect stores the user's latest plays and returns how many were new.
+func (c *Collector) Collect(ctx context.Context, user User) (int, error) {
+	token, err := c.clients.TokenSource(ctx, user.Token).Token()
+	if err != nil {
+		return 0, err
+	}
+	if token.AccessToken != user.Token.AccessToken {
+		if err := c.store.SaveToken(ctx, user.ID, token); err != nil {
+			return 0, err
+		}
+	}
+
+	spotifyClient := spotifyservice.New(c.clients.ClientForToken(ctx, token))
+	items, err := spotifyClient.PlayerRecentlyPlayedOpt(ctx, &spotify.RecentlyPlayedOptions{Limit: pollLimit})
+	if err != nil {
+		return 0, err
+	}
+	return c.store.AddPlays(ctx, user.ID, items)
+}
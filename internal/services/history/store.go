@@ -0,0 +1,344 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+// schema is applied by every NewStore, so each statement must be idempotent.
+// Plays are keyed by user and played-at time, which is how polls that overlap
+// are deduplicated.
+const schema = `
+CREATE TABLE IF NOT EXISTS users (
+	id           TEXT PRIMARY KEY,
+	token        TEXT NOT NULL,
+	consented_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS artists (
+	id   TEXT PRIMARY KEY,
+	name TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS albums (
+	id           TEXT PRIMARY KEY,
+	name         TEXT NOT NULL,
+	image_url    TEXT NOT NULL,
+	release_date TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tracks (
+	id          TEXT PRIMARY KEY,
+	name        TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL,
+	album_id    TEXT NOT NULL REFERENCES albums (id)
+);
+
+CREATE TABLE IF NOT EXISTS track_artists (
+	track_id  TEXT NOT NULL REFERENCES tracks (id),
+	position  INTEGER NOT NULL,
+	artist_id TEXT NOT NULL REFERENCES artists (id),
+	PRIMARY KEY (track_id, position)
+);
+
+CREATE TABLE IF NOT EXISTS plays (
+	user_id   TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	played_at INTEGER NOT NULL,
+	track_id  TEXT NOT NULL REFERENCES tracks (id),
+	PRIMARY KEY (user_id, played_at)
+);
+`
+
+// Store keeps the listening history of users who opted in, together with
+// the Spotify token used to collect it, in a SQLite database. Tokens are
+// stored encrypted.
+type Store struct {
+	db     *sql.DB
+	tokens *tokenCipher
+}
+
+// User is someone who opted in to having their history collected.
+type User struct {
+	ID    string
+	Token *oauth2.Token
+}
+
+// Play is one stored play, with the track rebuilt from stored metadata.
+type Play struct {
+	PlayedAt time.Time
+	Track    spotify.SimpleTrack
+}
+
+// Query selects a user's plays between From and To, newest first. A zero
+// From or To leaves that end open.
+type Query struct {
+	From   time.Time
+	To     time.Time
+	Offset int
+	Limit  int
+}
+
+// NewStore keeps history in db, e.g. from database.Open, creating its tables
+// if needed. Tokens are encrypted with a key derived from tokenSecret, so
+// they can't be read back once it changes.
+func NewStore(db *sql.DB, tokenSecret string) (*Store, error) {
+	tokens, err := newTokenCipher(tokenSecret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("history: creating schema: %w", err)
+	}
+	return &Store{db: db, tokens: tokens}, nil
+}
+
+// Consent records that the user opted in, with the token to collect their
+// history with. Opting in again only updates the token.
+func (s *Store) Consent(ctx context.Context, userID string, token *oauth2.Token) error {
+	sealed, err := s.tokens.seal(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO users (id, token, consented_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET token = excluded.token`,
+		userID, sealed, time.Now().UnixMilli())
+	return err
+}
+
+// Consented reports whether the user opted in.
+func (s *Store) Consented(ctx context.Context, userID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`, userID).Scan(&exists)
+	return exists, err
+}
+
+// Forget opts the user out and deletes their history and token.
+func (s *Store) Forget(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+	return err
+}
+
+// SaveToken replaces a user's token after it was refreshed.
+func (s *Store) SaveToken(ctx context.Context, userID string, token *oauth2.Token) error {
+	sealed, err := s.tokens.seal(token)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE users SET token = ? WHERE id = ?`, sealed, userID)
+	return err
+}
+
+// Users lists everyone who opted in. Users whose token can't be decrypted,
+// e.g. after the token secret changed, are left out until they opt in again.
+func (s *Store) Users(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, token FROM users ORDER BY consented_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var (
+			user   User
+			sealed string
+		)
+		if err := rows.Scan(&user.ID, &sealed); err != nil {
+			return nil, err
+		}
+		token, err := s.tokens.open(sealed)
+		if err != nil {
+			log.Printf("Skipping history of %s, their token can't be decrypted: %v", user.ID, err)
+			continue
+		}
+		user.Token = token
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// AddPlays stores plays and the metadata of their tracks, skipping plays
+// already stored. It returns how many plays were new.
+func (s *Store) AddPlays(ctx context.Context, userID string, items []spotify.RecentlyPlayedItem) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	added := 0
+	for _, item := range items {
+		// Local files have no ID to store them under
+		if item.Track.ID == "" {
+			continue
+		}
+		if err := saveTrack(ctx, tx, item.Track); err != nil {
+			return 0, err
+		}
+		result, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO plays (user_id, played_at, track_id) VALUES (?, ?, ?)`,
+			userID, item.PlayedAt.UnixMilli(), item.Track.ID)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		added += int(n)
+	}
+	return added, tx.Commit()
+}
+
+// saveTrack upserts a track with its album and artists, so names and images
+// follow whatever Spotify returned last.
+func saveTrack(ctx context.Context, tx *sql.Tx, track spotify.SimpleTrack) error {
+	var imageURL string
+	if len(track.Album.Images) > 0 {
+		imageURL = track.Album.Images[0].URL
+	}
+
+	var err error
+	exec := func(query string, args ...any) {
+		if err == nil {
+			_, err = tx.ExecContext(ctx, query, args...)
+		}
+	}
+	exec(`INSERT INTO albums (id, name, image_url, release_date) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, image_url = excluded.image_url, release_date = excluded.release_date`,
+		track.Album.ID, track.Album.Name, imageURL, track.Album.ReleaseDate)
+	exec(`INSERT INTO tracks (id, name, duration_ms, album_id) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, duration_ms = excluded.duration_ms, album_id = excluded.album_id`,
+		track.ID, track.Name, int64(track.Duration), track.Album.ID)
+	exec(`DELETE FROM track_artists WHERE track_id = ?`, track.ID)
+	for i, artist := range track.Artists {
+		exec(`INSERT INTO artists (id, name) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET name = excluded.name`,
+			artist.ID, artist.Name)
+		exec(`INSERT INTO track_artists (track_id, position, artist_id) VALUES (?, ?, ?)`,
+			track.ID, i, artist.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("history: saving track %s: %w", track.ID, err)
+	}
+	return nil
+}
+
+// where builds the filter shared by Plays and CountPlays.
+func (q Query) where(userID string) (string, []any) {
+	clauses := []string{"p.user_id = ?"}
+	args := []any{userID}
+	if !q.From.IsZero() {
+		clauses = append(clauses, "p.played_at >= ?")
+		args = append(args, q.From.UnixMilli())
+	}
+	if !q.To.IsZero() {
+		clauses = append(clauses, "p.played_at < ?")
+		args = append(args, q.To.UnixMilli())
+	}
+	return strings.Join(clauses, " AND "), args
+}
+
+// CountPlays counts the user's plays matching q, ignoring its paging.
+func (s *Store) CountPlays(ctx context.Context, userID string, q Query) (int, error) {
+	where, args := q.where(userID)
+	var count int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM plays p WHERE `+where, args...).Scan(&count)
+	return count, err
+}
+
+// Plays returns a page of the user's plays matching q, newest first.
+func (s *Store) Plays(ctx context.Context, userID string, q Query) ([]Play, error) {
+	where, args := q.where(userID)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.played_at, t.id, t.name, t.duration_ms, a.id, a.name, a.image_url, a.release_date
+		FROM plays p
+		JOIN tracks t ON t.id = p.track_id
+		JOIN albums a ON a.id = t.album_id
+		WHERE `+where+`
+		ORDER BY p.played_at DESC
+		LIMIT ? OFFSET ?`,
+		append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plays []Play
+	for rows.Next() {
+		var (
+			play     Play
+			playedAt int64
+			duration int64
+			imageURL string
+		)
+		track := &play.Track
+		if err := rows.Scan(&playedAt, &track.ID, &track.Name, &duration, &track.Album.ID, &track.Album.Name, &imageURL, &track.Album.ReleaseDate); err != nil {
+			return nil, err
+		}
+		play.PlayedAt = time.UnixMilli(playedAt)
+		track.Duration = spotify.Numeric(duration)
+		track.URI = spotify.URI("spotify:track:" + track.ID)
+		track.Album.URI = spotify.URI("spotify:album:" + track.Album.ID)
+		if imageURL != "" {
+			track.Album.Images = []spotify.Image{{URL: imageURL}}
+		}
+		plays = append(plays, play)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return plays, s.addArtists(ctx, plays)
+}
+
+// addArtists fills in the artists of each play's track, in credit order.
+func (s *Store) addArtists(ctx context.Context, plays []Play) error {
+	if len(plays) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(plays))
+	args := make([]any, len(plays))
+	for i, play := range plays {
+		placeholders[i] = "?"
+		args[i] = play.Track.ID
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT ta.track_id, ar.id, ar.name
+		FROM track_artists ta
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE ta.track_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY ta.track_id, ta.position`,
+		args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	artists := map[spotify.ID][]spotify.SimpleArtist{}
+	for rows.Next() {
+		var (
+			trackID spotify.ID
+			artist  spotify.SimpleArtist
+		)
+		if err := rows.Scan(&trackID, &artist.ID, &artist.Name); err != nil {
+			return err
+		}
+		artist.URI = spotify.URI("spotify:artist:" + artist.ID)
+		artists[trackID] = append(artists[trackID], artist)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range plays {
+		plays[i].Track.Artists = artists[plays[i].Track.ID]
+	}
+	return nil
+}
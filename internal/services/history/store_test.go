@@ -0,0 +1,60 @@
+package history
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thattomperson/spotifgo/internal/database"
+
+	"golang.org/x/oauth2"
+)
+
+func TestStoreEncryptsTokens(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store, err := NewStore(db, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := &oauth2.Token{AccessToken: "access-token", RefreshToken: "refresh-token"}
+	if err := store.Consent(ctx, "user", token); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored string
+	if err := db.QueryRow(`SELECT token FROM users WHERE id = 'user'`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "refresh-token") || strings.Contains(stored, "access-token") {
+		t.Errorf("token stored in plaintext: %s", stored)
+	}
+
+	users, err := store.Users(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Token.RefreshToken != "refresh-token" {
+		t.Errorf("users: got %+v, want the token back", users)
+	}
+
+	// Another secret can't read the token, so the user is skipped rather
+	// than failing everyone else's collection
+	other, err := NewStore(db, "another secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err = other.Users(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("users with another secret: got %+v, want none", users)
+	}
+}
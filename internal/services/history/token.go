@@ -0,0 +1,68 @@
+package history
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenCipher encrypts stored tokens with AES-GCM, so a copy of the database
+// alone doesn't hand out anyone's Spotify login.
+type tokenCipher struct {
+	aead cipher.AEAD
+}
+
+// newTokenCipher derives its key from secret, which sessions are signed with
+// too, hence the label keeping the two keys apart.
+func newTokenCipher(secret string) (*tokenCipher, error) {
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, "spotifgo history tokens", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenCipher{aead: aead}, nil
+}
+
+func (c *tokenCipher) seal(token *oauth2.Token) (string, error) {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	rand.Read(nonce)
+	return base64.StdEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+func (c *tokenCipher) open(sealed string) (*oauth2.Token, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, errors.New("history: sealed token too short")
+	}
+	nonce, ciphertext := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
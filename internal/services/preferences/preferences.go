@@ -0,0 +1,116 @@
+package preferences
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// maxRecentPlaylists is how many recently used playlists are remembered per
+// user.
+const maxRecentPlaylists = 5
+
+// schema is applied by every NewStore, so each statement must be idempotent.
+// Recent playlists are ordered by a per-user counter rather than a time, so
+// two uses in the same instant keep their order.
+const schema = `
+CREATE TABLE IF NOT EXISTS default_playlists (
+	user_id     TEXT PRIMARY KEY,
+	playlist_id TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS recent_playlists (
+	user_id     TEXT NOT NULL,
+	playlist_id TEXT NOT NULL,
+	used        INTEGER NOT NULL,
+	PRIMARY KEY (user_id, playlist_id)
+);
+`
+
+// Store keeps small per-user preferences in a SQLite database, keyed by
+// Spotify user ID so they follow the user across sessions and restarts.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore keeps preferences in db, e.g. from database.Open, creating its
+// tables if needed.
+func NewStore(db *sql.DB) (*Store, error) {
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("preferences: creating schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// DefaultPlaylist returns the playlist tracks are added to without asking,
+// or an empty ID when the user hasn't picked one.
+func (s *Store) DefaultPlaylist(ctx context.Context, userID string) (spotify.ID, error) {
+	var playlistID spotify.ID
+	err := s.db.QueryRowContext(ctx, `SELECT playlist_id FROM default_playlists WHERE user_id = ?`, userID).Scan(&playlistID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return playlistID, err
+}
+
+// SetDefaultPlaylist remembers the user's default playlist. An empty ID
+// clears it.
+func (s *Store) SetDefaultPlaylist(ctx context.Context, userID string, playlistID spotify.ID) error {
+	if playlistID == "" {
+		_, err := s.db.ExecContext(ctx, `DELETE FROM default_playlists WHERE user_id = ?`, userID)
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO default_playlists (user_id, playlist_id) VALUES (?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET playlist_id = excluded.playlist_id`,
+		userID, playlistID)
+	return err
+}
+
+// RecentPlaylists returns the playlists the user added to most recently,
+// newest first.
+func (s *Store) RecentPlaylists(ctx context.Context, userID string) ([]spotify.ID, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT playlist_id FROM recent_playlists WHERE user_id = ? ORDER BY used DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var playlists []spotify.ID
+	for rows.Next() {
+		var playlistID spotify.ID
+		if err := rows.Scan(&playlistID); err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, playlistID)
+	}
+	return playlists, rows.Err()
+}
+
+// UsePlaylist moves a playlist to the front of the user's recently used list.
+func (s *Store) UsePlaylist(ctx context.Context, userID string, playlistID spotify.ID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO recent_playlists (user_id, playlist_id, used)
+		VALUES (?, ?, (SELECT COALESCE(MAX(used), 0) + 1 FROM recent_playlists WHERE user_id = ?))
+		ON CONFLICT (user_id, playlist_id) DO UPDATE SET used = excluded.used`,
+		userID, playlistID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM recent_playlists WHERE user_id = ? AND playlist_id NOT IN (
+			SELECT playlist_id FROM recent_playlists WHERE user_id = ? ORDER BY used DESC LIMIT ?
+		)`,
+		userID, userID, maxRecentPlaylists); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,80 @@
+package preferences
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/thattomperson/spotifgo/internal/database"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+func TestStoreOutlivesReopening(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "spotifgo.db")
+	db, err := database.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetDefaultPlaylist(ctx, "user", "playlist1"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = database.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err = NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.DefaultPlaylist(ctx, "user"); err != nil || got != "playlist1" {
+		t.Errorf("default playlist: got %q, %v after reopening, want playlist1", got, err)
+	}
+	if got, err := store.DefaultPlaylist(ctx, "someone else"); err != nil || got != "" {
+		t.Errorf("someone else's default playlist: got %q, %v", got, err)
+	}
+
+	if err := store.SetDefaultPlaylist(ctx, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.DefaultPlaylist(ctx, "user"); got != "" {
+		t.Errorf("cleared default playlist: got %q", got)
+	}
+}
+
+func TestStoreKeepsRecentPlaylistsInOrder(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, err := NewStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []spotify.ID{"p1", "p2", "p3", "p4", "p5", "p6", "p3"} {
+		if err := store.UsePlaylist(ctx, "user", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.UsePlaylist(ctx, "other", "p9")
+
+	got, err := store.RecentPlaylists(ctx, "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []spotify.ID{"p3", "p6", "p5", "p4", "p2"}; !slices.Equal(got, want) {
+		t.Errorf("recent playlists: got %v, want %v", got, want)
+	}
+}
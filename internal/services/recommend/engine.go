@@ -0,0 +1,237 @@
+package recommend
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// Candidate sources and how much a track from each one counts towards its
+// score. A track found through several sources adds up their weights.
+const (
+	weightSeedArtist    = 3.0
+	weightRelatedArtist = 2.0
+	weightUserTop       = 1.0
+	weightRecent        = 0.5
+	// Bonus for tracks from the user's own listening that share an artist
+	// with the seed or its related artists.
+	weightFamiliarArtist = 1.5
+	weightPopularity     = 0.5
+)
+
+// Engine builds recommendations from data that is still available to every
+// app: the seed artist's top tracks, related artists' top tracks, and the
+// user's top tracks and recent history.
+type Engine struct {
+	relatedArtists     int
+	tracksPerArtist    int
+	maxTracksPerArtist int
+}
+
+func NewEngine() *Engine {
+	return &Engine{
+		relatedArtists:     5,
+		tracksPerArtist:    5,
+		maxTracksPerArtist: 3,
+	}
+}
+
+type candidate struct {
+	track spotify.FullTrack
+	score float64
+}
+
+type candidates struct {
+	mu     sync.Mutex
+	byID   map[spotify.ID]*candidate
+	byName map[string]spotify.ID
+}
+
+// add scores a track, ranked tracks count more the higher they appear.
+func (c *candidates) add(track spotify.FullTrack, weight float64, rank int, total int) {
+	if track.ID == "" {
+		return
+	}
+	score := weight
+	if total > 0 {
+		score *= 1 - float64(rank)/float64(total*2)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := track.ID
+	// The same song is often released on several albums, dedupe by name too.
+	if existing, ok := c.byName[nameKey(track)]; ok {
+		id = existing
+	}
+	if entry, ok := c.byID[id]; ok {
+		entry.score += score
+		return
+	}
+	c.byID[id] = &candidate{track: track, score: score}
+	c.byName[nameKey(track)] = id
+}
+
+func nameKey(track spotify.FullTrack) string {
+	var artist string
+	if len(track.Artists) > 0 {
+		artist = track.Artists[0].ID.String()
+	}
+	return artist + "|" + strings.ToLower(strings.TrimSpace(track.Name))
+}
+
+func (e *Engine) Recommend(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error) {
+	seedTrack, err := service.GetTrack(ctx, seed)
+	if err != nil {
+		return nil, err
+	}
+	if len(seedTrack.Artists) == 0 {
+		return nil, errors.New("recommend: seed track has no artists")
+	}
+	seedArtist := seedTrack.Artists[0].ID
+
+	pool := &candidates{byID: map[spotify.ID]*candidate{}, byName: map[string]spotify.ID{}}
+	nearbyArtists := map[spotify.ID]bool{seedArtist: true}
+	var nearbyMu sync.Mutex
+
+	wg := sync.WaitGroup{}
+
+	wg.Go(func() {
+		tracks, err := service.GetArtistsTopTracks(ctx, seedArtist, "from_token")
+		if err != nil {
+			log.Printf("Failed to get top tracks for seed artist %s: %v", seedArtist, err)
+			return
+		}
+		for i, track := range tracks {
+			pool.add(track, weightSeedArtist, i, len(tracks))
+		}
+	})
+	wg.Go(func() {
+		related, err := service.GetRelatedArtists(ctx, seedArtist)
+		if err != nil {
+			log.Printf("Failed to get related artists for %s: %v", seedArtist, err)
+			return
+		}
+		related = related[:min(len(related), e.relatedArtists)]
+
+		nearbyMu.Lock()
+		for _, artist := range related {
+			nearbyArtists[artist.ID] = true
+		}
+		nearbyMu.Unlock()
+
+		relatedWg := sync.WaitGroup{}
+		for _, artist := range related {
+			relatedWg.Go(func() {
+				tracks, err := service.GetArtistsTopTracks(ctx, artist.ID, "from_token")
+				if err != nil {
+					log.Printf("Failed to get top tracks for related artist %s: %v", artist.ID, err)
+					return
+				}
+				tracks = tracks[:min(len(tracks), e.tracksPerArtist)]
+				for i, track := range tracks {
+					pool.add(track, weightRelatedArtist, i, len(tracks))
+				}
+			})
+		}
+		relatedWg.Wait()
+	})
+
+	var userTracks []spotify.FullTrack
+	var userTracksMu sync.Mutex
+	wg.Go(func() {
+		tracks, err := spotifyservice.Collect(spotifyservice.TopTracks(ctx, service, spotifyservice.WithLimit(50)))
+		if err != nil {
+			log.Printf("Failed to get top tracks for recommendations: %v", err)
+		}
+		for i, track := range tracks {
+			pool.add(track, weightUserTop, i, len(tracks))
+		}
+		userTracksMu.Lock()
+		userTracks = append(userTracks, tracks...)
+		userTracksMu.Unlock()
+	})
+	wg.Go(func() {
+		items, err := service.PlayerRecentlyPlayed(ctx)
+		if err != nil {
+			log.Printf("Failed to get recent history for recommendations: %v", err)
+			return
+		}
+		for i, item := range items {
+			track := spotify.FullTrack{SimpleTrack: item.Track, Album: item.Track.Album}
+			pool.add(track, weightRecent, i, len(items))
+			userTracksMu.Lock()
+			userTracks = append(userTracks, track)
+			userTracksMu.Unlock()
+		}
+	})
+
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Reward the user's own tracks that are close to the seed.
+	for _, track := range userTracks {
+		for _, artist := range track.Artists {
+			if nearbyArtists[artist.ID] {
+				pool.add(track, weightFamiliarArtist, 0, 0)
+				break
+			}
+		}
+	}
+
+	return e.rank(pool, seedTrack, limit), nil
+}
+
+// rank orders candidates by score and keeps the list varied by capping how
+// many tracks a single artist can contribute.
+func (e *Engine) rank(pool *candidates, seed *spotify.FullTrack, limit int) []spotify.SimpleTrack {
+	seedName := nameKey(*seed)
+
+	ranked := make([]*candidate, 0, len(pool.byID))
+	for id, entry := range pool.byID {
+		if id == seed.ID || nameKey(entry.track) == seedName {
+			continue
+		}
+		entry.score += weightPopularity * float64(entry.track.Popularity) / 100
+		ranked = append(ranked, entry)
+	}
+	slices.SortFunc(ranked, func(a, b *candidate) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.track.ID, b.track.ID)
+	})
+
+	perArtist := map[spotify.ID]int{}
+	var tracks []spotify.SimpleTrack
+	for _, entry := range ranked {
+		if limit > 0 && len(tracks) >= limit {
+			break
+		}
+		var artist spotify.ID
+		if len(entry.track.Artists) > 0 {
+			artist = entry.track.Artists[0].ID
+		}
+		if perArtist[artist] >= e.maxTracksPerArtist {
+			continue
+		}
+		perArtist[artist]++
+
+		track := entry.track.SimpleTrack
+		track.Album = entry.track.Album
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
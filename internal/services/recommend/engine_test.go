@@ -0,0 +1,223 @@
+package recommend
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// fakeService serves fixed catalog and listening data. Methods it doesn't
+// override panic through the nil embedded Service.
+type fakeService struct {
+	spotifyservice.Service
+	tracks          map[spotify.ID]*spotify.FullTrack
+	artistTop       map[spotify.ID][]spotify.FullTrack
+	related         map[spotify.ID][]spotify.FullArtist
+	userTop         []spotify.FullTrack
+	recent          []spotify.RecentlyPlayedItem
+	recommendations []spotify.SimpleTrack
+	recommendErr    error
+}
+
+func newFakeService(tracks ...spotify.FullTrack) *fakeService {
+	s := &fakeService{
+		tracks:    map[spotify.ID]*spotify.FullTrack{},
+		artistTop: map[spotify.ID][]spotify.FullTrack{},
+		related:   map[spotify.ID][]spotify.FullArtist{},
+	}
+	for _, track := range tracks {
+		s.tracks[track.ID] = &track
+	}
+	return s
+}
+
+func (s *fakeService) GetTrack(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullTrack, error) {
+	track, ok := s.tracks[id]
+	if !ok {
+		return nil, spotify.Error{Status: 404, Message: "not found"}
+	}
+	return track, nil
+}
+
+func (s *fakeService) GetArtistsTopTracks(ctx context.Context, artistID spotify.ID, country string) ([]spotify.FullTrack, error) {
+	return s.artistTop[artistID], nil
+}
+
+func (s *fakeService) GetRelatedArtists(ctx context.Context, id spotify.ID) ([]spotify.FullArtist, error) {
+	return s.related[id], nil
+}
+
+func (s *fakeService) CurrentUsersTopTracks(ctx context.Context, page spotifyservice.PageOptions) (*spotify.FullTrackPage, error) {
+	start := min(page.Offset, len(s.userTop))
+	end := min(start+page.Limit, len(s.userTop))
+	result := &spotify.FullTrackPage{Tracks: s.userTop[start:end]}
+	result.Total = spotify.Numeric(len(s.userTop))
+	return result, nil
+}
+
+func (s *fakeService) PlayerRecentlyPlayed(ctx context.Context) ([]spotify.RecentlyPlayedItem, error) {
+	return s.recent, nil
+}
+
+func (s *fakeService) GetRecommendations(ctx context.Context, seeds spotify.Seeds, trackAttributes *spotify.TrackAttributes, opts ...spotify.RequestOption) (*spotify.Recommendations, error) {
+	if s.recommendErr != nil {
+		return nil, s.recommendErr
+	}
+	return &spotify.Recommendations{Tracks: s.recommendations}, nil
+}
+
+func track(id, artist, name string) spotify.FullTrack {
+	return spotify.FullTrack{SimpleTrack: spotify.SimpleTrack{
+		ID:      spotify.ID(id),
+		Name:    name,
+		Artists: []spotify.SimpleArtist{{ID: spotify.ID(artist)}},
+	}}
+}
+
+func played(track spotify.FullTrack) spotify.RecentlyPlayedItem {
+	return spotify.RecentlyPlayedItem{Track: track.SimpleTrack}
+}
+
+func ids(tracks []spotify.SimpleTrack) []spotify.ID {
+	result := make([]spotify.ID, len(tracks))
+	for i, track := range tracks {
+		result[i] = track.ID
+	}
+	return result
+}
+
+func recommend(t *testing.T, service *fakeService, limit int) []spotify.ID {
+	t.Helper()
+	tracks, err := NewEngine().Recommend(context.Background(), service, "seed", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids(tracks)
+}
+
+func TestEngineRanksByScore(t *testing.T) {
+	service := newFakeService(track("seed", "artistA", "Seed Song"))
+	service.artistTop["artistA"] = []spotify.FullTrack{track("a1", "artistA", "A1"), track("a2", "artistA", "A2")}
+	service.related["artistA"] = []spotify.FullArtist{{SimpleArtist: spotify.SimpleArtist{ID: "artistB"}}}
+	service.artistTop["artistB"] = []spotify.FullTrack{track("b1", "artistB", "B1")}
+	service.userTop = []spotify.FullTrack{track("b2", "artistB", "B2"), track("c1", "artistC", "C1")}
+	service.recent = []spotify.RecentlyPlayedItem{played(track("d1", "artistD", "D1"))}
+
+	// a1: seed artist's first, 3. b2: user's top from a related artist, 1 +
+	// 1.5. a2: seed artist's second, 2.25. b1: related artist's first, 2.
+	// c1: user's second top, 0.75. d1: recently played, 0.5.
+	got := recommend(t, service, 0)
+	if want := []spotify.ID{"a1", "b2", "a2", "b1", "c1", "d1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEnginePopularityBreaksCloseScores(t *testing.T) {
+	service := newFakeService(track("seed", "artistA", "Seed Song"))
+	quiet, popular := track("c1", "artistC", "C1"), track("c2", "artistC", "C2")
+	popular.Popularity = 100
+	service.userTop = []spotify.FullTrack{quiet, popular}
+
+	// c2 ranks 0.25 lower as the user's second top track, but popularity adds 0.5
+	if got, want := recommend(t, service, 0), []spotify.ID{"c2", "c1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestEngineLeavesOutTheSeed(t *testing.T) {
+	service := newFakeService(track("seed", "artistA", "Seed Song"))
+	service.artistTop["artistA"] = []spotify.FullTrack{
+		track("seed", "artistA", "Seed Song"),
+		// The same song released on another album
+		track("seed-single", "artistA", " seed song"),
+		track("a1", "artistA", "A1"),
+	}
+	service.recent = []spotify.RecentlyPlayedItem{played(track("seed", "artistA", "Seed Song"))}
+
+	if got, want := recommend(t, service, 0), []spotify.ID{"a1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want only the other track", got)
+	}
+}
+
+func TestEngineDedupes(t *testing.T) {
+	service := newFakeService(track("seed", "artistA", "Seed Song"))
+	service.artistTop["artistA"] = []spotify.FullTrack{track("a1", "artistA", "Album Song"), track("a2", "artistA", "A2")}
+	service.userTop = []spotify.FullTrack{
+		// By ID, adding to a2's score
+		track("a2", "artistA", "A2"),
+		// By artist and name, adding to a1's score
+		track("a1-single", "artistA", "album song"),
+	}
+	service.recent = []spotify.RecentlyPlayedItem{played(track("a2", "artistA", "A2"))}
+
+	got := recommend(t, service, 0)
+	if want := []spotify.ID{"a2", "a1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want each song once, a2 first with the most sources", got)
+	}
+}
+
+func TestEngineCapsTracksPerArtist(t *testing.T) {
+	service := newFakeService(track("seed", "artistA", "Seed Song"))
+	for _, id := range []string{"a1", "a2", "a3", "a4", "a5"} {
+		service.artistTop["artistA"] = append(service.artistTop["artistA"], track(id, "artistA", id))
+	}
+	service.userTop = []spotify.FullTrack{track("c1", "artistC", "C1")}
+
+	if got, want := recommend(t, service, 0), []spotify.ID{"a1", "a2", "a3", "c1"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want 3 tracks from the seed artist, then others", got)
+	}
+	if got, want := recommend(t, service, 2), []spotify.ID{"a1", "a2"}; !slices.Equal(got, want) {
+		t.Errorf("limited: got %v, want %v", got, want)
+	}
+}
+
+func TestEngineFailsWithoutSeed(t *testing.T) {
+	service := newFakeService()
+	if _, err := NewEngine().Recommend(context.Background(), service, "seed", 10); err == nil {
+		t.Error("want an error for a seed that doesn't exist")
+	}
+}
+
+func TestDefaultFallsBackToEngine(t *testing.T) {
+	tests := []struct {
+		name            string
+		recommendations []spotify.SimpleTrack
+		err             error
+		want            []spotify.ID
+	}{
+		{name: "Spotify recommends", recommendations: []spotify.SimpleTrack{{ID: "spotify1"}}, want: []spotify.ID{"spotify1"}},
+		{name: "Spotify fails", err: spotify.Error{Status: 404, Message: "Not Found"}, want: []spotify.ID{"a1"}},
+		{name: "Spotify has nothing", want: []spotify.ID{"a1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newFakeService(track("seed", "artistA", "Seed Song"))
+			service.artistTop["artistA"] = []spotify.FullTrack{track("a1", "artistA", "A1")}
+			service.recommendations = tt.recommendations
+			service.recommendErr = tt.err
+
+			tracks, err := Default().Recommend(context.Background(), service, "seed", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ids(tracks); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithFallbackReturnsFallbackError(t *testing.T) {
+	failed := errors.New("failed")
+	failing := RecommenderFunc(func(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error) {
+		return nil, failed
+	})
+	if _, err := WithFallback(failing, failing).Recommend(context.Background(), nil, "seed", 10); !errors.Is(err, failed) {
+		t.Errorf("got %v, want the fallback's error", err)
+	}
+}
@@ -0,0 +1,54 @@
+package recommend
+
+import (
+	"context"
+	"log"
+
+	spotifyservice "github.com/thattomperson/spotifgo/internal/services/spotify"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// Recommender suggests tracks similar to a seed track.
+type Recommender interface {
+	Recommend(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error)
+}
+
+// RecommenderFunc adapts a function to a Recommender.
+type RecommenderFunc func(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error)
+
+func (f RecommenderFunc) Recommend(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error) {
+	return f(ctx, service, seed, limit)
+}
+
+// Spotify asks Spotify's recommendations endpoint.
+func Spotify() Recommender {
+	return RecommenderFunc(func(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error) {
+		recommendations, err := service.GetRecommendations(ctx, spotify.Seeds{
+			Tracks: []spotify.ID{seed},
+		}, nil, spotify.Limit(limit))
+		if err != nil {
+			return nil, err
+		}
+		return recommendations.Tracks, nil
+	})
+}
+
+// WithFallback uses fallback whenever primary fails or has nothing to offer.
+func WithFallback(primary Recommender, fallback Recommender) Recommender {
+	return RecommenderFunc(func(ctx context.Context, service spotifyservice.Service, seed spotify.ID, limit int) ([]spotify.SimpleTrack, error) {
+		tracks, err := primary.Recommend(ctx, service, seed, limit)
+		if err == nil && len(tracks) > 0 {
+			return tracks, nil
+		}
+		if err != nil {
+			log.Printf("Primary recommender failed, using fallback: %v", err)
+		}
+		return fallback.Recommend(ctx, service, seed, limit)
+	})
+}
+
+// Default tries Spotify first and falls back to the local engine.
+func Default() Recommender {
+	return WithFallback(Spotify(), NewEngine())
+}
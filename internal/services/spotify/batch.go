@@ -0,0 +1,126 @@
+package spotify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/thattomperson/spotifgo/internal/utils"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	// MaxTracksPerLookup is the most IDs the several-tracks endpoint accepts.
+	MaxTracksPerLookup = 50
+	// MaxItemsPerPlaylistWrite is the most items a playlist add accepts.
+	MaxItemsPerPlaylistWrite = 100
+)
+
+// LookupTracks fetches tracks in batches. Tracks are returned in the order of
+// ids; IDs that are unknown or whose batch failed are returned as missing.
+func LookupTracks(ctx context.Context, service Service, ids []spotify.ID) (tracks []*spotify.FullTrack, missing []spotify.ID) {
+	for _, chunk := range utils.Chunk(ids, MaxTracksPerLookup) {
+		found, err := service.GetTracks(ctx, chunk)
+		if err != nil {
+			log.Printf("Failed to look up %d tracks: %v", len(chunk), err)
+			missing = append(missing, chunk...)
+			continue
+		}
+
+		for i, id := range chunk {
+			if i >= len(found) || found[i] == nil {
+				missing = append(missing, id)
+				continue
+			}
+			tracks = append(tracks, found[i])
+		}
+	}
+	return tracks, missing
+}
+
+// SavedTrackState reports which of ids are in the user's Liked Songs, checking
+// them in batches. Duplicate IDs are only checked once.
+func SavedTrackState(ctx context.Context, service Service, ids []spotify.ID) (map[spotify.ID]bool, error) {
+	unique := slices.Compact(slices.Sorted(slices.Values(ids)))
+	saved := make(map[spotify.ID]bool, len(unique))
+	for _, chunk := range utils.Chunk(unique, MaxTracksPerLookup) {
+		contains, err := service.UserHasTracks(ctx, chunk...)
+		if err != nil {
+			return nil, err
+		}
+		for i, id := range chunk {
+			saved[id] = i < len(contains) && contains[i]
+		}
+	}
+	return saved, nil
+}
+
+// PlaylistAddResult reports what happened to each chunk of a playlist write.
+type PlaylistAddResult struct {
+	Added  []spotify.ID
+	Failed []spotify.ID
+	Errors []error
+}
+
+// AddTracksToPlaylistChunked adds tracks in chunks so large lists don't hit
+// the per-request limit. A failed chunk doesn't stop the following ones.
+func AddTracksToPlaylistChunked(ctx context.Context, service Service, playlistID spotify.ID, ids []spotify.ID) PlaylistAddResult {
+	var result PlaylistAddResult
+	chunks := utils.Chunk(ids, MaxItemsPerPlaylistWrite)
+	for i, chunk := range chunks {
+		if _, err := service.AddTracksToPlaylist(ctx, playlistID, chunk...); err != nil {
+			result.Failed = append(result.Failed, chunk...)
+			result.Errors = append(result.Errors, fmt.Errorf("chunk %d/%d: %w", i+1, len(chunks), err))
+			continue
+		}
+		result.Added = append(result.Added, chunk...)
+	}
+	return result
+}
+
+// PlaylistTrackSet is the set of tracks in a playlist, by ID and by ISRC so
+// the same recording released on different albums can be matched too.
+type PlaylistTrackSet struct {
+	ids   map[spotify.ID]bool
+	isrcs map[string]bool
+}
+
+func NewPlaylistTrackSet() *PlaylistTrackSet {
+	return &PlaylistTrackSet{
+		ids:   map[spotify.ID]bool{},
+		isrcs: map[string]bool{},
+	}
+}
+
+// LoadPlaylistTracks reads every page of a playlist into a set.
+func LoadPlaylistTracks(ctx context.Context, service Service, playlistID spotify.ID) (*PlaylistTrackSet, error) {
+	set := NewPlaylistTrackSet()
+	for item, err := range PlaylistItems(ctx, service, playlistID) {
+		if err != nil {
+			return nil, err
+		}
+		if item.Track.Track != nil {
+			set.Add(item.Track.Track)
+		}
+	}
+	return set, nil
+}
+
+func (s *PlaylistTrackSet) Add(track *spotify.FullTrack) {
+	s.ids[track.ID] = true
+	if isrc := track.ExternalIDs["isrc"]; isrc != "" {
+		s.isrcs[isrc] = true
+	}
+}
+
+// Contains reports whether track is in the set, optionally also matching on
+// ISRC.
+func (s *PlaylistTrackSet) Contains(track *spotify.FullTrack, matchISRC bool) bool {
+	if s.ids[track.ID] {
+		return true
+	}
+	isrc := track.ExternalIDs["isrc"]
+	return matchISRC && isrc != "" && s.isrcs[isrc]
+}
@@ -0,0 +1,127 @@
+package spotify
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats is a snapshot of a cache's counters.
+type CacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache is a size bounded LRU cache whose entries also expire after a TTL.
+type Cache[K comparable, V any] struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	order   *list.List
+	items   map[K]*list.Element
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+func NewCache[K comparable, V any](maxSize int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		maxSize: maxSize,
+		ttl:     ttl,
+		order:   list.New(),
+		items:   map[K]*list.Element{},
+	}
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var empty V
+	element, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		return empty, false
+	}
+
+	entry := element.Value.(*cacheEntry[K, V])
+	if time.Now().After(entry.expires) {
+		c.removeElement(element)
+		c.misses.Add(1)
+		return empty, false
+	}
+
+	c.order.MoveToFront(element)
+	c.hits.Add(1)
+	return entry.value, true
+}
+
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*cacheEntry[K, V])
+		entry.value = value
+		entry.expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry[K, V]{key: key, value: value, expires: expires})
+	for c.maxSize > 0 && c.order.Len() > c.maxSize {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Cache[K, V]) Invalidate(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// InvalidateFunc drops every entry whose key matches.
+func (c *Cache[K, V]) InvalidateFunc(match func(K) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, element := range c.items {
+		if match(key) {
+			c.removeElement(element)
+		}
+	}
+}
+
+func (c *Cache[K, V]) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+func (c *Cache[K, V]) removeElement(element *list.Element) {
+	entry := element.Value.(*cacheEntry[K, V])
+	delete(c.items, entry.key)
+	c.order.Remove(element)
+}
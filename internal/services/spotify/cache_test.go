@@ -0,0 +1,82 @@
+package spotify
+
+import (
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestCacheExpiresAfterTTL(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		c := NewCache[string, int](10, time.Minute)
+		c.Set("a", 1)
+
+		time.Sleep(59 * time.Second)
+		if value, ok := c.Get("a"); !ok || value != 1 {
+			t.Fatalf("before TTL: got %v, %v", value, ok)
+		}
+
+		time.Sleep(2 * time.Second)
+		if _, ok := c.Get("a"); ok {
+			t.Fatal("after TTL: want a miss")
+		}
+		if stats := c.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Size != 0 {
+			t.Errorf("stats: got %+v", stats)
+		}
+	})
+}
+
+func TestCacheSetRefreshesTTL(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		c := NewCache[string, int](10, time.Minute)
+		c.Set("a", 1)
+		time.Sleep(45 * time.Second)
+		c.Set("a", 2)
+		time.Sleep(45 * time.Second)
+
+		if value, ok := c.Get("a"); !ok || value != 2 {
+			t.Fatalf("got %v, %v, want the refreshed value", value, ok)
+		}
+	})
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewCache[string, int](2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b: want it evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("%s: want it kept", key)
+		}
+	}
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Size != 2 {
+		t.Errorf("stats: got %+v", stats)
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	c := NewCache[string, int](10, time.Minute)
+	c.Set("user1:playlists?offset=0", 1)
+	c.Set("user1:playlists?offset=50", 2)
+	c.Set("user2:playlists?offset=0", 3)
+	c.Set("user2:top-tracks?", 4)
+
+	c.InvalidateFunc(func(key string) bool {
+		return strings.HasPrefix(key, "user1:")
+	})
+	c.Invalidate("user2:top-tracks?")
+
+	if stats := c.Stats(); stats.Size != 1 {
+		t.Errorf("size: got %d, want 1", stats.Size)
+	}
+	if _, ok := c.Get("user2:playlists?offset=0"); !ok {
+		t.Error("other user's playlists: want them kept")
+	}
+}
@@ -0,0 +1,308 @@
+package spotify
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// Caches holds the metadata caches shared by every request. Catalog objects
+// (tracks, albums, artists) barely change and are shared between users, while
+// personal data (profile, top tracks, playlists) is cached per user for a
+// short time.
+type Caches struct {
+	Tracks    *Cache[spotify.ID, *spotify.FullTrack]
+	Albums    *Cache[spotify.ID, *spotify.FullAlbum]
+	Artists   *Cache[spotify.ID, *spotify.FullArtist]
+	Users     *Cache[string, *spotify.PrivateUser]
+	TopTracks *Cache[string, *spotify.FullTrackPage]
+	Playlists *Cache[string, *spotify.SimplePlaylistPage]
+	Playlist  *Cache[string, *spotify.FullPlaylist]
+}
+
+func NewCaches() *Caches {
+	return &Caches{
+		Tracks:    NewCache[spotify.ID, *spotify.FullTrack](5000, time.Hour),
+		Albums:    NewCache[spotify.ID, *spotify.FullAlbum](1000, time.Hour),
+		Artists:   NewCache[spotify.ID, *spotify.FullArtist](2000, time.Hour),
+		Users:     NewCache[string, *spotify.PrivateUser](1000, 10*time.Minute),
+		TopTracks: NewCache[string, *spotify.FullTrackPage](1000, 5*time.Minute),
+		Playlists: NewCache[string, *spotify.SimplePlaylistPage](1000, time.Minute),
+		Playlist:  NewCache[string, *spotify.FullPlaylist](1000, time.Minute),
+	}
+}
+
+// Stats reports hit metrics for every cache, keyed by cache name.
+func (c *Caches) Stats() map[string]CacheStats {
+	return map[string]CacheStats{
+		"tracks":     c.Tracks.Stats(),
+		"albums":     c.Albums.Stats(),
+		"artists":    c.Artists.Stats(),
+		"users":      c.Users.Stats(),
+		"top_tracks": c.TopTracks.Stats(),
+		"playlists":  c.Playlists.Stats(),
+		"playlist":   c.Playlist.Stats(),
+	}
+}
+
+// Publish exposes Stats through expvar under name. Publishing the same name
+// twice is a no-op.
+func (c *Caches) Publish(name string) {
+	if expvar.Get(name) != nil {
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		return c.Stats()
+	}))
+}
+
+// InvalidateUser drops all personal data cached for a user.
+func (c *Caches) InvalidateUser(userKey string) {
+	prefix := userKey + ":"
+	match := func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}
+	c.Users.InvalidateFunc(match)
+	c.TopTracks.InvalidateFunc(match)
+	c.Playlists.InvalidateFunc(match)
+	c.Playlist.InvalidateFunc(match)
+}
+
+// NewCachingProvider wraps the services handed out by next with caches.
+// keyFn identifies the user so personal data is never shared.
+func NewCachingProvider(next Provider, caches *Caches, keyFn func(r *http.Request) string) Provider {
+	return ProviderFunc(func(r *http.Request) Service {
+		return &cachingService{
+			Service: next.ForRequest(r),
+			caches:  caches,
+			userKey: keyFn(r),
+		}
+	})
+}
+
+type cachingService struct {
+	Service
+	caches  *Caches
+	userKey string
+}
+
+func (s *cachingService) userCacheKey(key string) string {
+	return s.userKey + ":" + key
+}
+
+// Catalog calls with request options are passed straight through, as options
+// such as market change the response.
+func (s *cachingService) GetTrack(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullTrack, error) {
+	if len(opts) > 0 {
+		return s.Service.GetTrack(ctx, id, opts...)
+	}
+	if track, ok := s.caches.Tracks.Get(id); ok {
+		return track, nil
+	}
+
+	track, err := s.Service.GetTrack(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.Tracks.Set(id, track)
+	return track, nil
+}
+
+func (s *cachingService) GetTracks(ctx context.Context, ids []spotify.ID, opts ...spotify.RequestOption) ([]*spotify.FullTrack, error) {
+	if len(opts) > 0 {
+		return s.Service.GetTracks(ctx, ids, opts...)
+	}
+
+	tracks := make([]*spotify.FullTrack, len(ids))
+	var missing []spotify.ID
+	var missingIndexes []int
+	for i, id := range ids {
+		if track, ok := s.caches.Tracks.Get(id); ok {
+			tracks[i] = track
+			continue
+		}
+		missing = append(missing, id)
+		missingIndexes = append(missingIndexes, i)
+	}
+
+	if len(missing) == 0 {
+		return tracks, nil
+	}
+
+	fetched, err := s.Service.GetTracks(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for i, track := range fetched {
+		if i >= len(missingIndexes) {
+			break
+		}
+		tracks[missingIndexes[i]] = track
+		if track != nil {
+			s.caches.Tracks.Set(track.ID, track)
+		}
+	}
+	return tracks, nil
+}
+
+func (s *cachingService) GetArtist(ctx context.Context, id spotify.ID) (*spotify.FullArtist, error) {
+	if artist, ok := s.caches.Artists.Get(id); ok {
+		return artist, nil
+	}
+
+	artist, err := s.Service.GetArtist(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.Artists.Set(id, artist)
+	return artist, nil
+}
+
+func (s *cachingService) GetArtists(ctx context.Context, ids ...spotify.ID) ([]*spotify.FullArtist, error) {
+	artists := make([]*spotify.FullArtist, len(ids))
+	var missing []spotify.ID
+	var missingIndexes []int
+	for i, id := range ids {
+		if artist, ok := s.caches.Artists.Get(id); ok {
+			artists[i] = artist
+			continue
+		}
+		missing = append(missing, id)
+		missingIndexes = append(missingIndexes, i)
+	}
+
+	if len(missing) == 0 {
+		return artists, nil
+	}
+
+	fetched, err := s.Service.GetArtists(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+	for i, artist := range fetched {
+		if i >= len(missingIndexes) {
+			break
+		}
+		artists[missingIndexes[i]] = artist
+		if artist != nil {
+			s.caches.Artists.Set(artist.ID, artist)
+		}
+	}
+	return artists, nil
+}
+
+func (s *cachingService) GetAlbum(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullAlbum, error) {
+	if len(opts) > 0 {
+		return s.Service.GetAlbum(ctx, id, opts...)
+	}
+	if album, ok := s.caches.Albums.Get(id); ok {
+		return album, nil
+	}
+
+	album, err := s.Service.GetAlbum(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.Albums.Set(id, album)
+	return album, nil
+}
+
+// CurrentUser is looked up on most requests, as preferences and history are
+// kept under the user's Spotify ID.
+func (s *cachingService) CurrentUser(ctx context.Context) (*spotify.PrivateUser, error) {
+	if s.userKey == "" {
+		return s.Service.CurrentUser(ctx)
+	}
+	key := s.userCacheKey("me")
+	if user, ok := s.caches.Users.Get(key); ok {
+		return user, nil
+	}
+
+	user, err := s.Service.CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.Users.Set(key, user)
+	return user, nil
+}
+
+// Personal data is cached per user and per page, e.g. under
+// "top-tracks?limit=50&offset=0&time_range=short_term".
+func (s *cachingService) CurrentUsersTopTracks(ctx context.Context, page PageOptions) (*spotify.FullTrackPage, error) {
+	if s.userKey == "" {
+		return s.Service.CurrentUsersTopTracks(ctx, page)
+	}
+	key := s.userCacheKey("top-tracks?" + page.key())
+	if tracks, ok := s.caches.TopTracks.Get(key); ok {
+		return tracks, nil
+	}
+
+	tracks, err := s.Service.CurrentUsersTopTracks(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.TopTracks.Set(key, tracks)
+	return tracks, nil
+}
+
+func (s *cachingService) CurrentUsersPlaylists(ctx context.Context, page PageOptions) (*spotify.SimplePlaylistPage, error) {
+	if s.userKey == "" {
+		return s.Service.CurrentUsersPlaylists(ctx, page)
+	}
+	key := s.userCacheKey("playlists?" + page.key())
+	if playlists, ok := s.caches.Playlists.Get(key); ok {
+		return playlists, nil
+	}
+
+	playlists, err := s.Service.CurrentUsersPlaylists(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.Playlists.Set(key, playlists)
+	return playlists, nil
+}
+
+func (s *cachingService) GetPlaylist(ctx context.Context, playlistID spotify.ID) (*spotify.FullPlaylist, error) {
+	if s.userKey == "" {
+		return s.Service.GetPlaylist(ctx, playlistID)
+	}
+	key := s.userCacheKey("playlist:" + playlistID.String())
+	if playlist, ok := s.caches.Playlist.Get(key); ok {
+		return playlist, nil
+	}
+
+	playlist, err := s.Service.GetPlaylist(ctx, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	s.caches.Playlist.Set(key, playlist)
+	return playlist, nil
+}
+
+func (s *cachingService) AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) (string, error) {
+	snapshotID, err := s.Service.AddTracksToPlaylist(ctx, playlistID, trackIDs...)
+
+	// Invalidate even on failure, part of the write may have gone through.
+	invalidatePrefix(s, s.caches.Playlists, "playlists?")
+	s.caches.Playlist.Invalidate(s.userCacheKey("playlist:" + playlistID.String()))
+
+	return snapshotID, err
+}
+
+func (s *cachingService) CreatePlaylistForUser(ctx context.Context, userID, playlistName, description string, public bool, collaborative bool) (*spotify.FullPlaylist, error) {
+	playlist, err := s.Service.CreatePlaylistForUser(ctx, userID, playlistName, description, public, collaborative)
+	invalidatePrefix(s, s.caches.Playlists, "playlists?")
+	return playlist, err
+}
+
+// invalidatePrefix drops every page of the user's entry cached under prefix.
+func invalidatePrefix[V any](s *cachingService, cache *Cache[string, V], prefix string) {
+	prefix = s.userCacheKey(prefix)
+	cache.InvalidateFunc(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
@@ -0,0 +1,143 @@
+package spotify
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// countingService counts the calls that reach Spotify. Methods it doesn't
+// override panic through the nil embedded Service.
+type countingService struct {
+	Service
+	calls map[string]int
+}
+
+func (s *countingService) CurrentUser(ctx context.Context) (*spotify.PrivateUser, error) {
+	s.calls["me"]++
+	return &spotify.PrivateUser{User: spotify.User{ID: "spotify-user"}}, nil
+}
+
+func (s *countingService) CurrentUsersTopTracks(ctx context.Context, page PageOptions) (*spotify.FullTrackPage, error) {
+	s.calls["top-tracks?"+page.key()]++
+	return &spotify.FullTrackPage{}, nil
+}
+
+func (s *countingService) CurrentUsersPlaylists(ctx context.Context, page PageOptions) (*spotify.SimplePlaylistPage, error) {
+	s.calls["playlists?"+page.key()]++
+	return &spotify.SimplePlaylistPage{}, nil
+}
+
+func (s *countingService) GetPlaylist(ctx context.Context, playlistID spotify.ID) (*spotify.FullPlaylist, error) {
+	s.calls["playlist:"+playlistID.String()]++
+	return &spotify.FullPlaylist{}, nil
+}
+
+func (s *countingService) AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) (string, error) {
+	return "snapshot", nil
+}
+
+func newCountingService(userKey string) (*countingService, *Caches, Service) {
+	upstream := &countingService{calls: map[string]int{}}
+	caches := NewCaches()
+	provider := NewCachingProvider(ProviderFunc(func(r *http.Request) Service {
+		return upstream
+	}), caches, func(r *http.Request) string {
+		return userKey
+	})
+	return upstream, caches, provider.ForRequest(httptest.NewRequest(http.MethodPost, "/", nil))
+}
+
+func TestPageOptions(t *testing.T) {
+	page := PageOptions{Limit: 10, Offset: 20, TimeRange: spotify.ShortTermRange}
+	if got, want := page.key(), "limit=10&offset=20&time_range=short_term"; got != want {
+		t.Errorf("key: got %q, want %q", got, want)
+	}
+	if got := len(page.RequestOptions()); got != 3 {
+		t.Errorf("request options: got %d, want 3", got)
+	}
+	if got := len(PageOptions{}.RequestOptions()); got != 0 {
+		t.Errorf("request options: got %d for no options, want none", got)
+	}
+}
+
+func TestCachingServiceCachesCurrentUser(t *testing.T) {
+	upstream, caches, service := newCountingService("user")
+	ctx := context.Background()
+
+	for range 2 {
+		if user, err := service.CurrentUser(ctx); err != nil || user.ID != "spotify-user" {
+			t.Fatalf("got %v, %v", user, err)
+		}
+	}
+	if got := upstream.calls["me"]; got != 1 {
+		t.Errorf("got %d calls, want the repeat served from cache", got)
+	}
+
+	caches.InvalidateUser("user")
+	service.CurrentUser(ctx)
+	if got := upstream.calls["me"]; got != 2 {
+		t.Errorf("got %d calls, want a lookup after invalidating the user", got)
+	}
+}
+
+func TestCachingServiceCachesTopTracksPerOptions(t *testing.T) {
+	upstream, caches, service := newCountingService("user")
+	ctx := context.Background()
+
+	service.CurrentUsersTopTracks(ctx, PageOptions{Limit: 50, TimeRange: spotify.ShortTermRange})
+	service.CurrentUsersTopTracks(ctx, PageOptions{Limit: 50, TimeRange: spotify.ShortTermRange})
+	service.CurrentUsersTopTracks(ctx, PageOptions{Limit: 50, TimeRange: spotify.LongTermRange})
+
+	if got := upstream.calls["top-tracks?limit=50&offset=0&time_range=short_term"]; got != 1 {
+		t.Errorf("short term: got %d calls, want the repeat served from cache", got)
+	}
+	if got := upstream.calls["top-tracks?limit=50&offset=0&time_range=long_term"]; got != 1 {
+		t.Errorf("long term: got %d calls, want its own cache entry", got)
+	}
+	if stats := caches.TopTracks.Stats(); stats.Hits != 1 {
+		t.Errorf("stats: got %+v, want 1 hit", stats)
+	}
+}
+
+func TestCachingServiceInvalidatesEveryPlaylistPage(t *testing.T) {
+	upstream, _, service := newCountingService("user")
+	ctx := context.Background()
+
+	fetch := func() {
+		service.CurrentUsersPlaylists(ctx, PageOptions{Limit: 50})
+		service.CurrentUsersPlaylists(ctx, PageOptions{Limit: 50, Offset: 50})
+		service.GetPlaylist(ctx, "playlist1")
+	}
+	fetch()
+	fetch()
+	if got := upstream.calls["playlists?limit=50&offset=50&time_range="]; got != 1 {
+		t.Fatalf("second page: got %d calls, want the repeat served from cache", got)
+	}
+
+	service.AddTracksToPlaylist(ctx, "playlist1", "track1")
+	fetch()
+	for key, want := range map[string]int{
+		"playlists?limit=50&offset=0&time_range=":  2,
+		"playlists?limit=50&offset=50&time_range=": 2,
+		"playlist:playlist1":                       2,
+	} {
+		if got := upstream.calls[key]; got != want {
+			t.Errorf("%s: got %d calls after adding tracks, want %d", key, got, want)
+		}
+	}
+}
+
+func TestCachingServiceSkipsPersonalDataWithoutUser(t *testing.T) {
+	upstream, _, service := newCountingService("")
+	ctx := context.Background()
+
+	service.CurrentUsersPlaylists(ctx, PageOptions{})
+	service.CurrentUsersPlaylists(ctx, PageOptions{})
+	if got := upstream.calls["playlists?limit=0&offset=0&time_range="]; got != 2 {
+		t.Errorf("got %d calls, want nothing cached without a user", got)
+	}
+}
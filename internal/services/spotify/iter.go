@@ -0,0 +1,229 @@
+package spotify
+
+import (
+	"context"
+	"iter"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+type iterOptions struct {
+	limit     int
+	pageSize  int
+	timeRange spotify.Range
+}
+
+type IterOption func(*iterOptions)
+
+// WithLimit stops iterating after n items. Zero means no limit.
+func WithLimit(n int) IterOption {
+	return func(options *iterOptions) {
+		options.limit = n
+	}
+}
+
+// WithPageSize sets how many items are requested per page.
+func WithPageSize(n int) IterOption {
+	return func(options *iterOptions) {
+		options.pageSize = n
+	}
+}
+
+// WithTimeRange sets the period top tracks and artists are ranked over.
+func WithTimeRange(timeRange spotify.Range) IterOption {
+	return func(options *iterOptions) {
+		options.timeRange = timeRange
+	}
+}
+
+func newIterOptions(maxPageSize int, opts []IterOption) *iterOptions {
+	options := &iterOptions{pageSize: maxPageSize}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.pageSize <= 0 || options.pageSize > maxPageSize {
+		options.pageSize = maxPageSize
+	}
+	if options.limit > 0 && options.limit < options.pageSize {
+		options.pageSize = options.limit
+	}
+	return options
+}
+
+// page returns the options for the page starting at offset, which is also
+// what a caching Service keys the page on.
+func (o *iterOptions) page(offset int) PageOptions {
+	return PageOptions{Limit: o.pageSize, Offset: offset, TimeRange: o.timeRange}
+}
+
+// fetchPage returns one page of items and the total number available.
+type fetchPage[T any] func(ctx context.Context, page PageOptions) ([]T, int, error)
+
+// paged walks an offset paged endpoint until it runs out of items, hits the
+// limit, the context is cancelled or the consumer stops.
+func paged[T any](ctx context.Context, options *iterOptions, fetch fetchPage[T]) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var empty T
+		seen := 0
+		for offset := 0; ; offset += options.pageSize {
+			if err := ctx.Err(); err != nil {
+				yield(empty, err)
+				return
+			}
+
+			items, total, err := fetch(ctx, options.page(offset))
+			if err != nil {
+				yield(empty, err)
+				return
+			}
+
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+				seen++
+				if options.limit > 0 && seen >= options.limit {
+					return
+				}
+			}
+
+			if len(items) < options.pageSize || offset+len(items) >= total {
+				return
+			}
+		}
+	}
+}
+
+// Playlists iterates over the current user's playlists.
+func Playlists(ctx context.Context, service Service, opts ...IterOption) iter.Seq2[spotify.SimplePlaylist, error] {
+	return paged(ctx, newIterOptions(50, opts), func(ctx context.Context, page PageOptions) ([]spotify.SimplePlaylist, int, error) {
+		result, err := service.CurrentUsersPlaylists(ctx, page)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Playlists, int(result.Total), nil
+	})
+}
+
+// PlaylistItems iterates over the items of a playlist.
+func PlaylistItems(ctx context.Context, service Service, playlistID spotify.ID, opts ...IterOption) iter.Seq2[spotify.PlaylistItem, error] {
+	return paged(ctx, newIterOptions(100, opts), func(ctx context.Context, page PageOptions) ([]spotify.PlaylistItem, int, error) {
+		result, err := service.GetPlaylistItems(ctx, playlistID, page.RequestOptions()...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Items, int(result.Total), nil
+	})
+}
+
+// SavedTracks iterates over the current user's Liked Songs.
+func SavedTracks(ctx context.Context, service Service, opts ...IterOption) iter.Seq2[spotify.SavedTrack, error] {
+	return paged(ctx, newIterOptions(50, opts), func(ctx context.Context, page PageOptions) ([]spotify.SavedTrack, int, error) {
+		result, err := service.CurrentUsersTracks(ctx, page.RequestOptions()...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Tracks, int(result.Total), nil
+	})
+}
+
+// TopTracks iterates over the current user's top tracks.
+func TopTracks(ctx context.Context, service Service, opts ...IterOption) iter.Seq2[spotify.FullTrack, error] {
+	return paged(ctx, newIterOptions(50, opts), func(ctx context.Context, page PageOptions) ([]spotify.FullTrack, int, error) {
+		result, err := service.CurrentUsersTopTracks(ctx, page)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Tracks, int(result.Total), nil
+	})
+}
+
+// TopArtists iterates over the current user's top artists.
+func TopArtists(ctx context.Context, service Service, opts ...IterOption) iter.Seq2[spotify.FullArtist, error] {
+	return paged(ctx, newIterOptions(50, opts), func(ctx context.Context, page PageOptions) ([]spotify.FullArtist, int, error) {
+		result, err := service.CurrentUsersTopArtists(ctx, page.RequestOptions()...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Artists, int(result.Total), nil
+	})
+}
+
+// ArtistAlbums iterates over an artist's releases of the given types, or of
+// every type when none are given.
+func ArtistAlbums(ctx context.Context, service Service, artistID spotify.ID, types []spotify.AlbumType, opts ...IterOption) iter.Seq2[spotify.SimpleAlbum, error] {
+	return paged(ctx, newIterOptions(50, opts), func(ctx context.Context, page PageOptions) ([]spotify.SimpleAlbum, int, error) {
+		result, err := service.GetArtistAlbums(ctx, artistID, types, page.RequestOptions()...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Albums, int(result.Total), nil
+	})
+}
+
+// AlbumTracks iterates over an album's tracks in disc and track order.
+func AlbumTracks(ctx context.Context, service Service, albumID spotify.ID, opts ...IterOption) iter.Seq2[spotify.SimpleTrack, error] {
+	return paged(ctx, newIterOptions(50, opts), func(ctx context.Context, page PageOptions) ([]spotify.SimpleTrack, int, error) {
+		result, err := service.GetAlbumTracks(ctx, albumID, page.RequestOptions()...)
+		if err != nil {
+			return nil, 0, err
+		}
+		return result.Tracks, int(result.Total), nil
+	})
+}
+
+// RecentlyPlayed walks the recently played history backwards in time using
+// the before cursor, starting before the given time in Unix milliseconds, or
+// from now when before is zero.
+func RecentlyPlayed(ctx context.Context, service Service, before int64, opts ...IterOption) iter.Seq2[spotify.RecentlyPlayedItem, error] {
+	options := newIterOptions(50, opts)
+	return func(yield func(spotify.RecentlyPlayedItem, error) bool) {
+		seen := 0
+		for {
+			if err := ctx.Err(); err != nil {
+				yield(spotify.RecentlyPlayedItem{}, err)
+				return
+			}
+
+			items, err := service.PlayerRecentlyPlayedOpt(ctx, &spotify.RecentlyPlayedOptions{
+				Limit:         spotify.Numeric(options.pageSize),
+				BeforeEpochMs: before,
+			})
+			if err != nil {
+				yield(spotify.RecentlyPlayedItem{}, err)
+				return
+			}
+
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+				seen++
+				if options.limit > 0 && seen >= options.limit {
+					return
+				}
+			}
+
+			if len(items) < options.pageSize {
+				return
+			}
+			next := items[len(items)-1].PlayedAt.UnixMilli()
+			if before != 0 && next >= before {
+				return
+			}
+			before = next
+		}
+	}
+}
+
+// Collect drains an iterator into a slice, stopping at the first error.
+func Collect[T any](seq iter.Seq2[T, error]) ([]T, error) {
+	var items []T
+	for item, err := range seq {
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,246 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/thattomperson/spotifgo/internal/fakespotify"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// countingTransport counts the requests that reach the fake Spotify.
+type countingTransport struct {
+	base     http.RoundTripper
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return t.base.RoundTrip(req)
+}
+
+// fakeService is a Service against a fake Spotify with its seeded state.
+func fakeService(t *testing.T) (Service, *fakespotify.Server, *countingTransport) {
+	t.Helper()
+	fake := fakespotify.New()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	transport := &countingTransport{base: bearerTransport{token: fake.IssueToken(), base: http.DefaultTransport}}
+	client := spotify.New(&http.Client{Transport: transport}, spotify.WithBaseURL(server.URL+"/v1/"))
+	return New(client), fake, transport
+}
+
+func count[T any](seq iter.Seq2[T, error]) (int, error) {
+	items, err := Collect(seq)
+	return len(items), err
+}
+
+func TestPagedIterators(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    func(ctx context.Context, service Service) (int, error)
+		items    int
+		requests int
+	}{
+		{
+			name: "stops on a short last page",
+			count: func(ctx context.Context, service Service) (int, error) {
+				return count(Playlists(ctx, service, WithPageSize(2)))
+			},
+			items:    3,
+			requests: 2,
+		},
+		{
+			name: "stops on a full last page once the total is reached",
+			count: func(ctx context.Context, service Service) (int, error) {
+				return count(PlaylistItems(ctx, service, "playlist02", WithPageSize(5)))
+			},
+			items:    10,
+			requests: 2,
+		},
+		{
+			name: "fits a small limit in one page",
+			count: func(ctx context.Context, service Service) (int, error) {
+				return count(SavedTracks(ctx, service, WithLimit(3)))
+			},
+			items:    3,
+			requests: 1,
+		},
+		{
+			name: "stops at the limit across pages",
+			count: func(ctx context.Context, service Service) (int, error) {
+				return count(TopTracks(ctx, service, WithPageSize(4), WithLimit(6)))
+			},
+			items:    6,
+			requests: 2,
+		},
+		{
+			name: "caps the page size at the endpoint's maximum",
+			count: func(ctx context.Context, service Service) (int, error) {
+				return count(AlbumTracks(ctx, service, "album0101", WithPageSize(500)))
+			},
+			items:    5,
+			requests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _, transport := fakeService(t)
+			items, err := tt.count(context.Background(), service)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if items != tt.items || transport.requests != tt.requests {
+				t.Errorf("got %d items in %d requests, want %d in %d", items, transport.requests, tt.items, tt.requests)
+			}
+		})
+	}
+}
+
+func TestPagedStopsEarly(t *testing.T) {
+	t.Run("when the consumer breaks", func(t *testing.T) {
+		service, _, transport := fakeService(t)
+		for _, err := range Playlists(context.Background(), service, WithPageSize(1)) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			break
+		}
+		if transport.requests != 1 {
+			t.Errorf("got %d requests, want only the first page", transport.requests)
+		}
+	})
+
+	t.Run("when the context is cancelled", func(t *testing.T) {
+		service, _, transport := fakeService(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var items int
+		var lastErr error
+		for _, err := range Playlists(ctx, service, WithPageSize(1)) {
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			items++
+			cancel()
+		}
+		if !errors.Is(lastErr, context.Canceled) || items != 1 || transport.requests != 1 {
+			t.Errorf("got %d items in %d requests and %v, want 1 item then the cancellation", items, transport.requests, lastErr)
+		}
+	})
+}
+
+func TestRecentlyPlayed(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   int
+		opts     []IterOption
+		items    int
+		requests int
+	}{
+		// The last page is short, so there's nothing before it
+		{name: "walks back from now", opts: []IterOption{WithPageSize(8)}, items: 20, requests: 3},
+		// A full last page takes one more, empty, request to find the end
+		{name: "ends on an empty page", opts: []IterOption{WithPageSize(10)}, items: 20, requests: 3},
+		{name: "walks back from a cursor", before: 10, opts: []IterOption{WithPageSize(4)}, items: 10, requests: 3},
+		{name: "stops at the limit", opts: []IterOption{WithPageSize(5), WithLimit(7)}, items: 7, requests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, fake, transport := fakeService(t)
+			var before int64
+			if tt.before > 0 {
+				fake.Update(func(state *fakespotify.State) {
+					before = state.RecentlyPlayed[tt.before].PlayedAt.UnixMilli() + 1
+				})
+			}
+
+			items, err := Collect(RecentlyPlayed(context.Background(), service, before, tt.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(items) != tt.items || transport.requests != tt.requests {
+				t.Errorf("got %d items in %d requests, want %d in %d", len(items), transport.requests, tt.items, tt.requests)
+			}
+			playedAt := make([]time.Time, len(items))
+			for i, item := range items {
+				playedAt[i] = item.PlayedAt
+			}
+			if !slices.IsSortedFunc(playedAt, func(a, b time.Time) int { return b.Compare(a) }) || len(slices.Compact(playedAt)) != len(playedAt) {
+				t.Errorf("got plays at %v, want each play once, newest first", playedAt)
+			}
+		})
+	}
+}
+
+func TestCollectStopsAtFirstError(t *testing.T) {
+	failed := errors.New("failed")
+	seq := func(yield func(int, error) bool) {
+		_ = yield(1, nil) && yield(2, nil) && yield(0, failed) && yield(3, nil)
+	}
+	items, err := Collect(seq)
+	if !errors.Is(err, failed) || !slices.Equal(items, []int{1, 2}) {
+		t.Errorf("got %v, %v, want the items before the error", items, err)
+	}
+}
+
+// pagedPlaylists serves playlists in the pages asked for, counting requests.
+type pagedPlaylists struct {
+	Service
+	playlists []spotify.SimplePlaylist
+	requests  int
+}
+
+func (s *pagedPlaylists) CurrentUsersPlaylists(ctx context.Context, page PageOptions) (*spotify.SimplePlaylistPage, error) {
+	s.requests++
+	end := min(page.Offset+page.Limit, len(s.playlists))
+
+	result := &spotify.SimplePlaylistPage{Playlists: s.playlists[page.Offset:end]}
+	result.Total = spotify.Numeric(len(s.playlists))
+	return result, nil
+}
+
+func TestPlaylistsPagesAreCached(t *testing.T) {
+	upstream := &pagedPlaylists{}
+	for i := range 5 {
+		upstream.playlists = append(upstream.playlists, spotify.SimplePlaylist{ID: spotify.ID("playlist" + strconv.Itoa(i))})
+	}
+	service := NewCachingProvider(ProviderFunc(func(r *http.Request) Service {
+		return upstream
+	}), NewCaches(), func(r *http.Request) string {
+		return "user"
+	}).ForRequest(httptest.NewRequest(http.MethodPost, "/", nil))
+	ctx := context.Background()
+
+	for range 2 {
+		playlists, err := Collect(Playlists(ctx, service, WithPageSize(2)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(playlists) != 5 || playlists[4].ID != "playlist4" {
+			t.Fatalf("got %d playlists, want all 5 in order", len(playlists))
+		}
+	}
+	if upstream.requests != 3 {
+		t.Errorf("got %d requests, want 3 pages fetched once", upstream.requests)
+	}
+
+	// A different page size asks for different pages
+	if _, err := Collect(Playlists(ctx, service, WithPageSize(5))); err != nil {
+		t.Fatal(err)
+	}
+	if upstream.requests != 4 {
+		t.Errorf("got %d requests, want 1 more for the bigger page", upstream.requests)
+	}
+}
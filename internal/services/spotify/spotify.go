@@ -1,2 +1,288 @@
 package spotify
 
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// Service is everything the handlers need from the Spotify Web API. Method
+// names and signatures follow zmb3/spotify so the real implementation is a
+// thin wrapper and fakes are easy to write. Personal data that is cached takes
+// PageOptions instead of request options, so the cache can key on them.
+type Service interface {
+	// Player
+	PlayerCurrentlyPlaying(ctx context.Context, opts ...spotify.RequestOption) (*spotify.CurrentlyPlaying, error)
+	PlayerRecentlyPlayed(ctx context.Context) ([]spotify.RecentlyPlayedItem, error)
+	PlayerRecentlyPlayedOpt(ctx context.Context, opt *spotify.RecentlyPlayedOptions) ([]spotify.RecentlyPlayedItem, error)
+	GetQueue(ctx context.Context) (*spotify.Queue, error)
+	QueueSong(ctx context.Context, trackID spotify.ID) error
+	QueueSongOpt(ctx context.Context, trackID spotify.ID, opt *spotify.PlayOptions) error
+	PlayerDevices(ctx context.Context) ([]spotify.PlayerDevice, error)
+	TransferPlayback(ctx context.Context, deviceID spotify.ID, play bool) error
+	PlayerState(ctx context.Context, opts ...spotify.RequestOption) (*spotify.PlayerState, error)
+	Play(ctx context.Context) error
+	PlayOpt(ctx context.Context, opt *spotify.PlayOptions) error
+	Pause(ctx context.Context) error
+	Next(ctx context.Context) error
+	Previous(ctx context.Context) error
+	Seek(ctx context.Context, position int) error
+	Shuffle(ctx context.Context, shuffle bool) error
+	Repeat(ctx context.Context, state string) error
+	Volume(ctx context.Context, percent int) error
+
+	// Personalisation
+	CurrentUser(ctx context.Context) (*spotify.PrivateUser, error)
+	CurrentUsersTopTracks(ctx context.Context, page PageOptions) (*spotify.FullTrackPage, error)
+	CurrentUsersTopArtists(ctx context.Context, opts ...spotify.RequestOption) (*spotify.FullArtistPage, error)
+	CurrentUsersTracks(ctx context.Context, opts ...spotify.RequestOption) (*spotify.SavedTrackPage, error)
+	UserHasTracks(ctx context.Context, ids ...spotify.ID) ([]bool, error)
+	AddTracksToLibrary(ctx context.Context, ids ...spotify.ID) error
+	RemoveTracksFromLibrary(ctx context.Context, ids ...spotify.ID) error
+	GetRecommendations(ctx context.Context, seeds spotify.Seeds, trackAttributes *spotify.TrackAttributes, opts ...spotify.RequestOption) (*spotify.Recommendations, error)
+
+	// Catalog
+	GetTrack(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullTrack, error)
+	GetTracks(ctx context.Context, ids []spotify.ID, opts ...spotify.RequestOption) ([]*spotify.FullTrack, error)
+	GetArtist(ctx context.Context, id spotify.ID) (*spotify.FullArtist, error)
+	GetArtists(ctx context.Context, ids ...spotify.ID) ([]*spotify.FullArtist, error)
+	GetArtistsTopTracks(ctx context.Context, artistID spotify.ID, country string) ([]spotify.FullTrack, error)
+	GetRelatedArtists(ctx context.Context, id spotify.ID) ([]spotify.FullArtist, error)
+	GetArtistAlbums(ctx context.Context, artistID spotify.ID, ts []spotify.AlbumType, opts ...spotify.RequestOption) (*spotify.SimpleAlbumPage, error)
+	GetAlbum(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullAlbum, error)
+	GetAlbumTracks(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.SimpleTrackPage, error)
+	Search(ctx context.Context, query string, t spotify.SearchType, opts ...spotify.RequestOption) (*spotify.SearchResult, error)
+
+	// Playlists
+	CurrentUsersPlaylists(ctx context.Context, page PageOptions) (*spotify.SimplePlaylistPage, error)
+	GetPlaylist(ctx context.Context, playlistID spotify.ID) (*spotify.FullPlaylist, error)
+	GetPlaylistItems(ctx context.Context, playlistID spotify.ID, opts ...spotify.RequestOption) (*spotify.PlaylistItemPage, error)
+	AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) (string, error)
+	CreatePlaylistForUser(ctx context.Context, userID, playlistName, description string, public bool, collaborative bool) (*spotify.FullPlaylist, error)
+}
+
+// PageOptions select a page of a paged endpoint. Unlike spotify.RequestOption
+// they can be read back, which is what cache keys are made of.
+type PageOptions struct {
+	Limit  int
+	Offset int
+	// TimeRange only applies to top tracks and artists
+	TimeRange spotify.Range
+}
+
+// RequestOptions turns the options that are set into zmb3/spotify options. A
+// page with a limit always asks for its offset, even the first one.
+func (o PageOptions) RequestOptions() []spotify.RequestOption {
+	var opts []spotify.RequestOption
+	if o.Limit > 0 {
+		opts = append(opts, spotify.Limit(o.Limit))
+	}
+	if o.Limit > 0 || o.Offset > 0 {
+		opts = append(opts, spotify.Offset(o.Offset))
+	}
+	if o.TimeRange != "" {
+		opts = append(opts, spotify.Timerange(o.TimeRange))
+	}
+	return opts
+}
+
+// key identifies the page in a cache key, e.g.
+// "limit=50&offset=0&time_range=short_term".
+func (o PageOptions) key() string {
+	return fmt.Sprintf("limit=%d&offset=%d&time_range=%s", o.Limit, o.Offset, o.TimeRange)
+}
+
+// Provider hands out the Service for the user making a request.
+type Provider interface {
+	ForRequest(r *http.Request) Service
+}
+
+// ProviderFunc adapts a function to a Provider.
+type ProviderFunc func(r *http.Request) Service
+
+func (f ProviderFunc) ForRequest(r *http.Request) Service {
+	return f(r)
+}
+
+// NewProvider builds a Provider on top of a function that returns an
+// authenticated client for a request, e.g. auth.Auth.GetSpotifyClient.
+func NewProvider(clientFn func(r *http.Request) *spotify.Client) Provider {
+	return ProviderFunc(func(r *http.Request) Service {
+		return New(clientFn(r))
+	})
+}
+
+type client struct {
+	client *spotify.Client
+}
+
+// New wraps a zmb3/spotify client.
+func New(c *spotify.Client) Service {
+	return &client{client: c}
+}
+
+func (c *client) PlayerCurrentlyPlaying(ctx context.Context, opts ...spotify.RequestOption) (*spotify.CurrentlyPlaying, error) {
+	return c.client.PlayerCurrentlyPlaying(ctx, opts...)
+}
+
+func (c *client) PlayerRecentlyPlayed(ctx context.Context) ([]spotify.RecentlyPlayedItem, error) {
+	return c.client.PlayerRecentlyPlayed(ctx)
+}
+
+func (c *client) PlayerRecentlyPlayedOpt(ctx context.Context, opt *spotify.RecentlyPlayedOptions) ([]spotify.RecentlyPlayedItem, error) {
+	return c.client.PlayerRecentlyPlayedOpt(ctx, opt)
+}
+
+func (c *client) GetQueue(ctx context.Context) (*spotify.Queue, error) {
+	return c.client.GetQueue(ctx)
+}
+
+func (c *client) QueueSong(ctx context.Context, trackID spotify.ID) error {
+	return c.client.QueueSong(ctx, trackID)
+}
+
+func (c *client) QueueSongOpt(ctx context.Context, trackID spotify.ID, opt *spotify.PlayOptions) error {
+	return c.client.QueueSongOpt(ctx, trackID, opt)
+}
+
+func (c *client) PlayerDevices(ctx context.Context) ([]spotify.PlayerDevice, error) {
+	return c.client.PlayerDevices(ctx)
+}
+
+func (c *client) TransferPlayback(ctx context.Context, deviceID spotify.ID, play bool) error {
+	return c.client.TransferPlayback(ctx, deviceID, play)
+}
+
+func (c *client) PlayerState(ctx context.Context, opts ...spotify.RequestOption) (*spotify.PlayerState, error) {
+	return c.client.PlayerState(ctx, opts...)
+}
+
+func (c *client) Play(ctx context.Context) error {
+	return c.client.Play(ctx)
+}
+
+func (c *client) PlayOpt(ctx context.Context, opt *spotify.PlayOptions) error {
+	return c.client.PlayOpt(ctx, opt)
+}
+
+func (c *client) Pause(ctx context.Context) error {
+	return c.client.Pause(ctx)
+}
+
+func (c *client) Next(ctx context.Context) error {
+	return c.client.Next(ctx)
+}
+
+func (c *client) Previous(ctx context.Context) error {
+	return c.client.Previous(ctx)
+}
+
+func (c *client) Seek(ctx context.Context, position int) error {
+	return c.client.Seek(ctx, position)
+}
+
+func (c *client) Shuffle(ctx context.Context, shuffle bool) error {
+	return c.client.Shuffle(ctx, shuffle)
+}
+
+func (c *client) Repeat(ctx context.Context, state string) error {
+	return c.client.Repeat(ctx, state)
+}
+
+func (c *client) Volume(ctx context.Context, percent int) error {
+	return c.client.Volume(ctx, percent)
+}
+
+func (c *client) CurrentUser(ctx context.Context) (*spotify.PrivateUser, error) {
+	return c.client.CurrentUser(ctx)
+}
+
+func (c *client) CurrentUsersTopTracks(ctx context.Context, page PageOptions) (*spotify.FullTrackPage, error) {
+	return c.client.CurrentUsersTopTracks(ctx, page.RequestOptions()...)
+}
+
+func (c *client) CurrentUsersTopArtists(ctx context.Context, opts ...spotify.RequestOption) (*spotify.FullArtistPage, error) {
+	return c.client.CurrentUsersTopArtists(ctx, opts...)
+}
+
+func (c *client) CurrentUsersTracks(ctx context.Context, opts ...spotify.RequestOption) (*spotify.SavedTrackPage, error) {
+	return c.client.CurrentUsersTracks(ctx, opts...)
+}
+
+func (c *client) UserHasTracks(ctx context.Context, ids ...spotify.ID) ([]bool, error) {
+	return c.client.UserHasTracks(ctx, ids...)
+}
+
+func (c *client) AddTracksToLibrary(ctx context.Context, ids ...spotify.ID) error {
+	return c.client.AddTracksToLibrary(ctx, ids...)
+}
+
+func (c *client) RemoveTracksFromLibrary(ctx context.Context, ids ...spotify.ID) error {
+	return c.client.RemoveTracksFromLibrary(ctx, ids...)
+}
+
+func (c *client) GetRecommendations(ctx context.Context, seeds spotify.Seeds, trackAttributes *spotify.TrackAttributes, opts ...spotify.RequestOption) (*spotify.Recommendations, error) {
+	return c.client.GetRecommendations(ctx, seeds, trackAttributes, opts...)
+}
+
+func (c *client) GetTrack(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullTrack, error) {
+	return c.client.GetTrack(ctx, id, opts...)
+}
+
+func (c *client) GetTracks(ctx context.Context, ids []spotify.ID, opts ...spotify.RequestOption) ([]*spotify.FullTrack, error) {
+	return c.client.GetTracks(ctx, ids, opts...)
+}
+
+func (c *client) GetArtist(ctx context.Context, id spotify.ID) (*spotify.FullArtist, error) {
+	return c.client.GetArtist(ctx, id)
+}
+
+func (c *client) GetArtists(ctx context.Context, ids ...spotify.ID) ([]*spotify.FullArtist, error) {
+	return c.client.GetArtists(ctx, ids...)
+}
+
+func (c *client) GetArtistsTopTracks(ctx context.Context, artistID spotify.ID, country string) ([]spotify.FullTrack, error) {
+	return c.client.GetArtistsTopTracks(ctx, artistID, country)
+}
+
+func (c *client) GetRelatedArtists(ctx context.Context, id spotify.ID) ([]spotify.FullArtist, error) {
+	return c.client.GetRelatedArtists(ctx, id)
+}
+
+func (c *client) GetArtistAlbums(ctx context.Context, artistID spotify.ID, ts []spotify.AlbumType, opts ...spotify.RequestOption) (*spotify.SimpleAlbumPage, error) {
+	return c.client.GetArtistAlbums(ctx, artistID, ts, opts...)
+}
+
+func (c *client) GetAlbum(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.FullAlbum, error) {
+	return c.client.GetAlbum(ctx, id, opts...)
+}
+
+func (c *client) GetAlbumTracks(ctx context.Context, id spotify.ID, opts ...spotify.RequestOption) (*spotify.SimpleTrackPage, error) {
+	return c.client.GetAlbumTracks(ctx, id, opts...)
+}
+
+func (c *client) Search(ctx context.Context, query string, t spotify.SearchType, opts ...spotify.RequestOption) (*spotify.SearchResult, error) {
+	return c.client.Search(ctx, query, t, opts...)
+}
+
+func (c *client) CurrentUsersPlaylists(ctx context.Context, page PageOptions) (*spotify.SimplePlaylistPage, error) {
+	return c.client.CurrentUsersPlaylists(ctx, page.RequestOptions()...)
+}
+
+func (c *client) GetPlaylist(ctx context.Context, playlistID spotify.ID) (*spotify.FullPlaylist, error) {
+	return c.client.GetPlaylist(ctx, playlistID)
+}
+
+func (c *client) GetPlaylistItems(ctx context.Context, playlistID spotify.ID, opts ...spotify.RequestOption) (*spotify.PlaylistItemPage, error) {
+	return c.client.GetPlaylistItems(ctx, playlistID, opts...)
+}
+
+func (c *client) AddTracksToPlaylist(ctx context.Context, playlistID spotify.ID, trackIDs ...spotify.ID) (string, error) {
+	return c.client.AddTracksToPlaylist(ctx, playlistID, trackIDs...)
+}
+
+func (c *client) CreatePlaylistForUser(ctx context.Context, userID, playlistName, description string, public bool, collaborative bool) (*spotify.FullPlaylist, error) {
+	return c.client.CreatePlaylistForUser(ctx, userID, playlistName, description, public, collaborative)
+}
@@ -0,0 +1,486 @@
+package spotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zmb3/spotify/v2"
+)
+
+// VCRMode selects whether a VCR talks to Spotify or plays back a cassette.
+type VCRMode string
+
+const (
+	VCROff    VCRMode = ""
+	VCRRecord VCRMode = "record"
+	VCRReplay VCRMode = "replay"
+)
+
+// ParseVCRMode reads a mode as given in SPOTIFY_VCR.
+func ParseVCRMode(mode string) (VCRMode, error) {
+	switch VCRMode(strings.ToLower(strings.TrimSpace(mode))) {
+	case VCROff, "off":
+		return VCROff, nil
+	case VCRRecord:
+		return VCRRecord, nil
+	case VCRReplay:
+		return VCRReplay, nil
+	}
+	return VCROff, fmt.Errorf("spotify: unknown VCR mode %q, want record or replay", mode)
+}
+
+// Interaction is one recorded request and its response.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	JSON   json.RawMessage `json:"json,omitempty"`
+	Body   string          `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	JSON   json.RawMessage   `json:"json,omitempty"`
+	Body   string            `json:"body,omitempty"`
+}
+
+// Cassette is the golden file a VCR reads and writes.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// recordedHeaders are the only response headers worth keeping; everything
+// else is noise that changes on every request.
+var recordedHeaders = []string{"Content-Type", "Retry-After"}
+
+// VCR is an http.RoundTripper that records Spotify API traffic to a cassette
+// or replays it without touching the network. Recorded traffic is scrubbed:
+// credentials are dropped and user IDs are replaced with stable placeholders,
+// so cassettes are safe to commit.
+//
+// Replay matches on method, path, query and body. Matching interactions are
+// served in recorded order and the last one repeats, so polled endpoints keep
+// answering.
+type VCR struct {
+	mode VCRMode
+	path string
+	base http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	served   map[int]bool
+	users    map[string]string
+	// names maps user display names to the placeholder of their user
+	names map[string]string
+}
+
+type VCROption func(*VCR)
+
+// WithVCRBase sets the transport used to reach Spotify while recording. It
+// must add credentials itself, e.g. an oauth2.Transport.
+func WithVCRBase(base http.RoundTripper) VCROption {
+	return func(v *VCR) {
+		v.base = base
+	}
+}
+
+// NewVCR opens the cassette at path. In replay mode the cassette must exist;
+// in record mode it is started from scratch and written after every request.
+func NewVCR(path string, mode VCRMode, opts ...VCROption) (*VCR, error) {
+	v := &VCR{
+		mode:   mode,
+		path:   path,
+		base:   http.DefaultTransport,
+		served: map[int]bool{},
+		users:  map[string]string{},
+		names:  map[string]string{},
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	if mode == VCRReplay {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("spotify: reading cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &v.cassette); err != nil {
+			return nil, fmt.Errorf("spotify: parsing cassette %s: %w", path, err)
+		}
+	}
+	return v, nil
+}
+
+// VCRFromEnv opens the cassette called name using SPOTIFY_VCR for the mode
+// and SPOTIFY_VCR_DIR for the directory (default testdata/spotify). It returns
+// nil when SPOTIFY_VCR is unset, so callers can fall back to a fake. When
+// recording, SPOTIFY_VCR_TOKEN is sent as the bearer token, and requests go
+// to SPOTIFY_API_URL instead of Spotify when it is set, e.g. to record
+// against the fake.
+func VCRFromEnv(name string, opts ...VCROption) (*VCR, error) {
+	mode, err := ParseVCRMode(os.Getenv("SPOTIFY_VCR"))
+	if err != nil || mode == VCROff {
+		return nil, err
+	}
+
+	dir := os.Getenv("SPOTIFY_VCR_DIR")
+	if dir == "" {
+		dir = filepath.Join("testdata", "spotify")
+	}
+	if mode == VCRRecord {
+		base := http.DefaultTransport
+		if apiURL := os.Getenv("SPOTIFY_API_URL"); apiURL != "" {
+			parsed, err := url.Parse(apiURL)
+			if err != nil {
+				return nil, fmt.Errorf("spotify: SPOTIFY_API_URL: %w", err)
+			}
+			base = rebaseTransport{apiURL: parsed, base: base}
+		}
+		if token := os.Getenv("SPOTIFY_VCR_TOKEN"); token != "" {
+			base = bearerTransport{token: token, base: base}
+		}
+		opts = append([]VCROption{WithVCRBase(base)}, opts...)
+	}
+	return NewVCR(filepath.Join(dir, sanitizeCassetteName(name)+".json"), mode, opts...)
+}
+
+// Mode reports whether the VCR is recording or replaying.
+func (v *VCR) Mode() VCRMode {
+	return v.mode
+}
+
+// Client returns a zmb3 client whose requests go through the VCR.
+func (v *VCR) Client() *spotify.Client {
+	return spotify.New(&http.Client{Transport: v})
+}
+
+// Provider returns a Provider that serves every request from the VCR, for
+// handing to handler.NewRpcHandlers in tests.
+func (v *VCR) Provider() Provider {
+	return ProviderFunc(func(r *http.Request) Service {
+		return New(v.Client())
+	})
+}
+
+func (v *VCR) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if v.mode == VCRReplay {
+		return v.replay(req, body)
+	}
+	return v.record(req, body)
+}
+
+func (v *VCR) record(req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := v.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    v.scrubString(normalizeURL(req.URL)),
+		},
+		Response: RecordedResponse{
+			Status: resp.StatusCode,
+			Header: map[string]string{},
+		},
+	}
+	interaction.Request.JSON, interaction.Request.Body = v.scrubBody(body)
+	interaction.Response.JSON, interaction.Response.Body = v.scrubBody(respBody)
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			interaction.Response.Header[name] = value
+		}
+	}
+
+	v.cassette.Interactions = append(v.cassette.Interactions, interaction)
+	if err := v.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (v *VCR) replay(req *http.Request, body []byte) (*http.Response, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	method := req.Method
+	target := normalizeURL(req.URL)
+	reqJSON, reqBody := normalizeBody(body)
+
+	last := -1
+	for i, interaction := range v.cassette.Interactions {
+		recorded := interaction.Request
+		if recorded.Method != method || recorded.URL != target || recorded.Body != reqBody || !jsonEqual(recorded.JSON, reqJSON) {
+			continue
+		}
+		last = i
+		if !v.served[i] {
+			break
+		}
+	}
+	if last == -1 {
+		return nil, fmt.Errorf("spotify: cassette %s has no interaction for %s %s", v.path, method, target)
+	}
+	v.served[last] = true
+
+	recorded := v.cassette.Interactions[last].Response
+	header := http.Header{}
+	for name, value := range recorded.Header {
+		header.Set(name, value)
+	}
+	respBody := []byte(recorded.Body)
+	if len(recorded.JSON) > 0 {
+		respBody = recorded.JSON
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(recorded.Status) + " " + http.StatusText(recorded.Status),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+func (v *VCR) save() error {
+	data, err := json.MarshalIndent(v.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(v.path), 0o755); err != nil {
+		return fmt.Errorf("spotify: writing cassette: %w", err)
+	}
+	return os.WriteFile(v.path, append(data, '\n'), 0o644)
+}
+
+// secretFields are JSON fields whose values never make it into a cassette.
+var secretFields = map[string]bool{
+	"access_token":  true,
+	"refresh_token": true,
+	"email":         true,
+	"birthdate":     true,
+}
+
+// scrubBody scrubs a JSON body, returning it indented for readable diffs, or
+// returns a non-JSON body as a string.
+func (v *VCR) scrubBody(body []byte) (json.RawMessage, string) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, ""
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, v.scrubString(string(body))
+	}
+	v.collectUsers("", value)
+	scrubbed, err := json.MarshalIndent(v.scrubValue("", value), "", "  ")
+	if err != nil {
+		return nil, v.scrubString(string(body))
+	}
+	return scrubbed, ""
+}
+
+// collectUsers assigns placeholders to the IDs of every user object in value,
+// so the same user gets the same placeholder everywhere in the cassette.
+func (v *VCR) collectUsers(key string, value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		if isUserObject(key, value) {
+			if id, ok := value["id"].(string); ok && id != "" {
+				placeholder := v.userPlaceholder(id)
+				if name, ok := value["display_name"].(string); ok && name != "" && name != placeholder {
+					v.names[name] = placeholder
+				}
+			}
+		}
+		for childKey, child := range value {
+			v.collectUsers(childKey, child)
+		}
+	case []any:
+		for _, child := range value {
+			v.collectUsers(key, child)
+		}
+	}
+}
+
+// isUserObject reports whether value, found under key, describes a user.
+// Owners and adders are users even when the API leaves out their type.
+func isUserObject(key string, value map[string]any) bool {
+	uri, _ := value["uri"].(string)
+	return key == "owner" || key == "added_by" || value["type"] == "user" || strings.HasPrefix(uri, "spotify:user:")
+}
+
+func (v *VCR) userPlaceholder(id string) string {
+	if placeholder, ok := v.users[id]; ok {
+		return placeholder
+	}
+	placeholder := fmt.Sprintf("user-%d", len(v.users)+1)
+	v.users[id] = placeholder
+	return placeholder
+}
+
+func (v *VCR) scrubValue(key string, value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		isUser := isUserObject(key, value)
+		// Read before the loop, which may already have replaced it
+		id, hasID := value["id"].(string)
+		for childKey, child := range value {
+			if isUser && hasID && childKey == "display_name" {
+				value[childKey] = v.userPlaceholder(id)
+				continue
+			}
+			if isUser && childKey == "images" {
+				value[childKey] = []any{}
+				continue
+			}
+			value[childKey] = v.scrubValue(childKey, child)
+		}
+		return value
+	case []any:
+		for i, child := range value {
+			value[i] = v.scrubValue(key, child)
+		}
+		return value
+	case string:
+		if secretFields[key] {
+			return "REDACTED"
+		}
+		return v.scrubString(value)
+	}
+	return value
+}
+
+var userReferencePattern = regexp.MustCompile(`(spotify:user:|/users/)([^/?&"\s]+)`)
+
+// scrubString replaces a string that is wholly a known user's ID or display
+// name, and user IDs inside URIs and URLs. Other text is left alone, so a user
+// called "Tom" doesn't turn "Tom Petty" into "user-1 Petty".
+func (v *VCR) scrubString(s string) string {
+	if placeholder, ok := v.users[s]; ok {
+		return placeholder
+	}
+	if placeholder, ok := v.names[s]; ok {
+		return placeholder
+	}
+	return userReferencePattern.ReplaceAllStringFunc(s, func(match string) string {
+		parts := userReferencePattern.FindStringSubmatch(match)
+		id, err := url.PathUnescape(parts[2])
+		if err != nil {
+			id = parts[2]
+		}
+		if strings.HasPrefix(id, "user-") {
+			return match
+		}
+		return parts[1] + v.userPlaceholder(id)
+	})
+}
+
+// normalizeURL drops the host so cassettes work against any API base URL and
+// sorts the query so equivalent requests match.
+func normalizeURL(u *url.URL) string {
+	target := u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.Query().Encode()
+	}
+	return target
+}
+
+func normalizeBody(body []byte) (json.RawMessage, string) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, ""
+	}
+	if json.Valid(body) {
+		return body, ""
+	}
+	return nil, string(body)
+}
+
+func jsonEqual(a, b json.RawMessage) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	var av, bv any
+	if json.Unmarshal(a, &av) != nil || json.Unmarshal(b, &bv) != nil {
+		return bytes.Equal(a, b)
+	}
+	ac, _ := json.Marshal(av)
+	bc, _ := json.Marshal(bv)
+	return bytes.Equal(ac, bc)
+}
+
+var unsafeCassetteChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeCassetteName turns a test name like "TestQueue/empty queue" into a
+// file name.
+func sanitizeCassetteName(name string) string {
+	return unsafeCassetteChars.ReplaceAllString(name, "_")
+}
+
+// bearerTransport adds a fixed access token, for recording with a token
+// copied from a real session.
+type bearerTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.base.RoundTrip(req)
+}
+
+// rebaseTransport sends requests meant for the Spotify API to another API
+// base URL such as "http://localhost:9090/v1/".
+type rebaseTransport struct {
+	apiURL *url.URL
+	base   http.RoundTripper
+}
+
+func (t rebaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.apiURL.Scheme
+	req.URL.Host = t.apiURL.Host
+	req.URL.Path = strings.TrimSuffix(t.apiURL.Path, "/") + "/" + strings.TrimPrefix(req.URL.Path, "/v1/")
+	req.URL.RawPath = ""
+	req.Host = ""
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,152 @@
+package spotify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVCRScrubsSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+		w.Header().Set("X-Access-Token", "secret-access")
+		switch r.URL.Path {
+		case "/v1/me":
+			json.NewEncoder(w).Encode(map[string]any{
+				"id":           "realuser",
+				"type":         "user",
+				"uri":          "spotify:user:realuser",
+				"display_name": "Real Name",
+				"email":        "real@example.com",
+				"images":       []any{map[string]any{"url": "https://i.scdn.co/face.jpg"}},
+			})
+		case "/v1/users/realuser/playlists":
+			body, _ := io.ReadAll(r.Body)
+			json.NewEncoder(w).Encode(map[string]any{
+				"name":          string(body),
+				"owner":         map[string]any{"id": "realuser", "display_name": "Real Name"},
+				"access_token":  "secret-access",
+				"refresh_token": "secret-refresh",
+			})
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	vcr, err := NewVCR(path, VCRRecord, WithVCRBase(bearerTransport{token: "secret-access", base: http.DefaultTransport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: vcr}
+	for _, req := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/v1/me", ""},
+		{http.MethodPost, "/v1/users/realuser/playlists", "Real Name"},
+	} {
+		httpReq, err := http.NewRequest(req.method, server.URL+req.path, strings.NewReader(req.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d", req.path, resp.StatusCode)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette := string(data)
+	for _, secret := range []string{"secret-access", "secret-refresh", "secret-cookie", "realuser", "Real Name", "real@example.com", "face.jpg"} {
+		if strings.Contains(cassette, secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, cassette)
+		}
+	}
+	for _, want := range []string{`"/v1/users/user-1/playlists"`, `"body": "user-1"`, `"REDACTED"`} {
+		if !strings.Contains(cassette, want) {
+			t.Errorf("cassette is missing %s:\n%s", want, cassette)
+		}
+	}
+
+	// The scrubbed cassette still replays
+	replay, err := NewVCR(path, VCRReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := replay.Client().CurrentUser(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != "user-1" || user.DisplayName != "user-1" {
+		t.Errorf("replayed user: got %s %q", user.ID, user.DisplayName)
+	}
+}
+
+func TestVCRKeepsCatalogNamesContainingUserNames(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/me":
+			json.NewEncoder(w).Encode(map[string]any{
+				"id":           "tom",
+				"type":         "user",
+				"display_name": "Tom",
+			})
+		case "/v1/me/top/tracks":
+			json.NewEncoder(w).Encode(map[string]any{
+				"items": []any{map[string]any{
+					"id":      "track1",
+					"name":    "Tomorrow",
+					"artists": []any{map[string]any{"id": "artist1", "name": "Tom Petty"}},
+					"album":   map[string]any{"id": "album1", "name": "Full Moon Fever"},
+				}},
+				"total": 1,
+			})
+		}
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	vcr, err := NewVCR(path, VCRRecord, WithVCRBase(http.DefaultTransport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: vcr}
+	for _, target := range []string{"/v1/me", "/v1/me/top/tracks"} {
+		resp, err := client.Get(server.URL + target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette := string(data)
+	for _, want := range []string{`"Tomorrow"`, `"Tom Petty"`, `"display_name": "user-1"`} {
+		if !strings.Contains(cassette, want) {
+			t.Errorf("cassette is missing %s:\n%s", want, cassette)
+		}
+	}
+	if strings.Contains(cassette, `"Tom"`) {
+		t.Errorf("cassette contains the display name:\n%s", cassette)
+	}
+}
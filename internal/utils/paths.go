@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type basePathKey struct{}
+
+// WithBasePath returns a copy of ctx carrying the path prefix the app is
+// mounted under, e.g. "/music". Each app carries its own, so several can
+// share a process.
+func WithBasePath(ctx context.Context, basePath string) context.Context {
+	return context.WithValue(ctx, basePathKey{}, NormalizeBasePath(basePath))
+}
+
+// BasePathMiddleware makes basePath available to Path for every request.
+func BasePathMiddleware(basePath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(WithBasePath(r.Context(), basePath)))
+		})
+	}
+}
+
+// BasePath returns the normalized base path carried by ctx, without a
+// trailing slash.
+func BasePath(ctx context.Context) string {
+	basePath, _ := ctx.Value(basePathKey{}).(string)
+	return basePath
+}
+
+// Path prefixes an app-absolute path with the base path carried by ctx.
+// Example: with base path "/music", "/rpc/queue-track" → "/music/rpc/queue-track"
+func Path(ctx context.Context, path string) string {
+	return JoinPath(BasePath(ctx), path)
+}
+
+// JoinPath prefixes an app-absolute path with a normalized base path.
+func JoinPath(basePath string, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return basePath + path
+}
+
+// CookiePath returns the path cookies should be scoped to.
+func CookiePath(ctx context.Context) string {
+	if basePath := BasePath(ctx); basePath != "" {
+		return basePath
+	}
+	return "/"
+}
+
+// NormalizeBasePath ensures a leading slash and strips trailing slashes.
+// Example: "music/" → "/music", "/" → ""
+func NormalizeBasePath(path string) string {
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
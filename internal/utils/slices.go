@@ -7,3 +7,15 @@ func MapSlice[T any, R any](slice []T, fn func(T) R) []R {
 	}
 	return result
 }
+
+// Chunk splits a slice into consecutive chunks of at most size items.
+// Example: Chunk([1 2 3 4 5], 2) → [[1 2] [3 4] [5]]
+func Chunk[T any](slice []T, size int) [][]T {
+	var chunks [][]T
+	for size > 0 && len(slice) > 0 {
+		end := min(size, len(slice))
+		chunks = append(chunks, slice[:end])
+		slice = slice[end:]
+	}
+	return chunks
+}
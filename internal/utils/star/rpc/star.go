@@ -1,15 +1,19 @@
 package rpc
 
 import (
+	"context"
 	"net/url"
 	"strings"
+
+	"github.com/thattomperson/spotifgo/internal/utils"
 )
 
 type RpcOptions struct {
-	parameters url.Values
-	include    string
-	exclude    string
-	method     string
+	parameters          url.Values
+	include             string
+	exclude             string
+	method              string
+	requestCancellation string
 }
 
 type RpcOption func(*RpcOptions)
@@ -47,15 +51,21 @@ func WithMethod(method string) RpcOption {
 	}
 }
 
-func Post(path string, opts ...RpcOption) string {
-	return Rpc(path, append(opts, WithMethod("post"))...)
+func WithRequestCancellation(mode string) RpcOption {
+	return func(options *RpcOptions) {
+		options.requestCancellation = mode
+	}
 }
 
-func Get(path string, opts ...RpcOption) string {
-	return Rpc(path, append(opts, WithMethod("get"))...)
+func Post(ctx context.Context, path string, opts ...RpcOption) string {
+	return Rpc(ctx, path, append(opts, WithMethod("post"))...)
 }
 
-func Rpc(path string, opts ...RpcOption) string {
+func Get(ctx context.Context, path string, opts ...RpcOption) string {
+	return Rpc(ctx, path, append(opts, WithMethod("get"))...)
+}
+
+func Rpc(ctx context.Context, path string, opts ...RpcOption) string {
 	options := &RpcOptions{
 		method: "post",
 	}
@@ -63,7 +73,7 @@ func Rpc(path string, opts ...RpcOption) string {
 		opt(options)
 	}
 
-	parsedUrl, err := url.Parse("/rpc/" + path)
+	parsedUrl, err := url.Parse(utils.Path(ctx, "/rpc/"+path))
 	if err != nil {
 		panic(err)
 	}
@@ -86,5 +96,9 @@ func Rpc(path string, opts ...RpcOption) string {
 		rpcOptions = append(rpcOptions, "filterSignals: {"+strings.Join(rpcFiltersOptions, ", ")+"}")
 	}
 
+	if options.requestCancellation != "" {
+		rpcOptions = append(rpcOptions, "requestCancellation: '"+options.requestCancellation+"'")
+	}
+
 	return "@post('" + parsedUrl.String() + "', {" + strings.Join(rpcOptions, ", ") + "})"
 }
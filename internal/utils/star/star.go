@@ -1,12 +1,16 @@
 package star
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/url"
 
 	"github.com/a-h/templ"
 	datastar "github.com/starfederation/datastar-go/datastar"
+	"github.com/thattomperson/spotifgo/internal/ui/components/button"
 	"github.com/thattomperson/spotifgo/internal/ui/components/toast"
+	"github.com/thattomperson/spotifgo/internal/utils"
 )
 
 func ReadSignals[T any](w http.ResponseWriter, r *http.Request) *T {
@@ -34,6 +38,10 @@ func (r *DatastarWriter[T]) Append(selector string, component templ.Component) {
 	r.Generator.PatchElementTempl(component, datastar.WithSelector(selector), datastar.WithModeAppend())
 }
 
+func (r *DatastarWriter[T]) Prepend(selector string, component templ.Component) {
+	r.Generator.PatchElementTempl(component, datastar.WithSelector(selector), datastar.WithModePrepend())
+}
+
 func (r *DatastarWriter[T]) UpdateSignals(signals *T) {
 	r.Generator.MarshalAndPatchSignals(signals)
 }
@@ -46,6 +54,36 @@ func WithVariant(variant toast.Variant) ToastOption {
 	}
 }
 
+// WithAction adds a button to the toast that runs onClick, e.g. an rpc.Post.
+func WithAction(label string, onClick string) ToastOption {
+	return func(props *toast.Props) {
+		props.Action = toastButton(label, button.Props{
+			Attributes: templ.Attributes{
+				"data-on-click":          onClick,
+				"data-tui-toast-dismiss": "",
+			},
+		})
+	}
+}
+
+// WithLink adds a button to the toast that opens href in a new tab.
+func WithLink(label string, href string) ToastOption {
+	return func(props *toast.Props) {
+		props.Action = toastButton(label, button.Props{
+			Href:   href,
+			Target: "_blank",
+		})
+	}
+}
+
+func toastButton(label string, props button.Props) templ.Component {
+	props.Variant = button.VariantOutline
+	props.Size = button.SizeSm
+	return templ.ComponentFunc(func(ctx context.Context, w io.Writer) error {
+		return button.Button(props).Render(templ.WithChildren(ctx, templ.Raw(templ.EscapeString(label))), w)
+	})
+}
+
 func (r *DatastarWriter[T]) ShowToast(title string, description string, opts ...ToastOption) {
 	props := &toast.Props{
 		Title:       title,
@@ -57,6 +95,17 @@ func (r *DatastarWriter[T]) ShowToast(title string, description string, opts ...
 	r.Append("#toasts", toast.Toast(*props))
 }
 
+// ToastHandler responds with a stream that only shows a toast. It is useful
+// for middleware that rejects a request before it reaches a StarFunc.
+func ToastHandler(title string, description string, opts ...ToastOption) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := &DatastarWriter[struct{}]{
+			Generator: datastar.NewSSE(w, r),
+		}
+		response.ShowToast(title, description, opts...)
+	})
+}
+
 type StarFunc[T any] func(*DatastarWriter[T], *T, *http.Request)
 
 func Star[T any](fn StarFunc[T]) http.HandlerFunc {
@@ -75,8 +124,8 @@ func Star[T any](fn StarFunc[T]) http.HandlerFunc {
 	})
 }
 
-func Rpc(method string, data url.Values) string {
-	parsedUrl, err := url.Parse("/rpc/" + method + "?" + data.Encode())
+func Rpc(ctx context.Context, method string, data url.Values) string {
+	parsedUrl, err := url.Parse(utils.Path(ctx, "/rpc/"+method) + "?" + data.Encode())
 	if err != nil {
 		panic(err)
 	}